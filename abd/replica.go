@@ -27,6 +27,7 @@ type entry struct {
 // Each read and write operation proceed in Get and Set phase
 type Replica struct {
 	paxi.Node
+	db  paxi.Database
 	cid int
 
 	log     map[int]*entry
@@ -36,7 +37,8 @@ type Replica struct {
 // NewReplica generates ABD replica
 func NewReplica(id paxi.ID) *Replica {
 	r := new(Replica)
-	r.Node = paxi.NewNode(id)
+	r.db = paxi.NewDatabase(id)
+	r.Node = paxi.NewNodeWithStateMachine(id, r.db)
 	r.log = make(map[int]*entry)
 	r.version = make(map[paxi.Key]int)
 	r.Register(paxi.Request{}, r.handleRequest)
@@ -49,8 +51,16 @@ func NewReplica(id paxi.ID) *Replica {
 
 func (r *Replica) handleRequest(m paxi.Request) {
 	log.Debugf("Node %s received Request %v", r.ID(), m)
+	// ABD only supports atomic read and write registers
+	if op := m.Command.Operation(); op != paxi.OpGet && op != paxi.OpPut {
+		m.Reply(paxi.Reply{
+			Command: m.Command,
+			Err:     paxi.ErrNotSupported,
+		})
+		return
+	}
 	k := m.Command.Key
-	v := r.Get(k)
+	v := r.db.Get(k)
 	version := r.version[k]
 	r.cid++
 	// entry save my local verion of value
@@ -71,7 +81,7 @@ func (r *Replica) handleRequest(m paxi.Request) {
 }
 
 func (r *Replica) handleGet(m Get) {
-	v := r.Node.Get(m.Key)
+	v := r.db.Get(m.Key)
 	r.Send(m.ID, GetReply{
 		ID:      r.ID(),
 		CID:     m.CID,
@@ -84,7 +94,7 @@ func (r *Replica) handleGet(m Get) {
 func (r *Replica) handleSet(m Set) {
 	if m.Version > r.version[m.Key] {
 		// update local value
-		r.Node.Put(m.Key, m.Value)
+		r.db.Put(m.Key, m.Value)
 		r.version[m.Key] = m.Version
 	}
 	r.Send(m.ID, SetReply{
@@ -103,7 +113,7 @@ func (r *Replica) handleGetReply(m GetReply) {
 		e.value = m.Value
 		e.version = m.Version
 		// update local value
-		r.Node.Put(m.Key, m.Value)
+		r.db.Put(m.Key, m.Value)
 		r.version[m.Key] = m.Version
 	}
 	e.getQuorum.ACK(m.ID)
@@ -122,7 +132,7 @@ func (r *Replica) handleGetReply(m GetReply) {
 			e.value = e.r.Command.Value
 			e.version++
 			// write new value to local database first
-			r.Node.Put(e.r.Command.Key, e.r.Command.Value)
+			r.db.Put(e.r.Command.Key, e.r.Command.Value)
 			r.version[m.Key] = e.version
 			r.Broadcast(Set{
 				ID:      r.ID(),
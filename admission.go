@@ -0,0 +1,96 @@
+package paxi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// ErrOverloaded is returned to client when node sheds its request
+var ErrOverloaded = Error("node overloaded")
+
+// Load is the admission state of node
+type Load struct {
+	Messages int    // protocol messages queued
+	Requests int    // client requests queued
+	Pending  int64  // client requests admitted and not yet replied
+	Admitted uint64 // total client requests admitted
+	Shed     uint64 // total client requests rejected
+}
+
+// admission keeps client requests in a queue separate from protocol messages,
+// protocol messages are always handled first and never rejected
+type admission struct {
+	requests chan interface{}
+	pending  int64
+	admitted uint64
+	shed     uint64
+}
+
+func newAdmission() admission {
+	return admission{requests: make(chan interface{}, config.RequestBuffer)}
+}
+
+// admit queues client request or transaction without blocking,
+// returns false if node has too many pending requests or the queue is full
+func (n *node) admit(m interface{}) bool {
+	if config.MaxPending > 0 && atomic.LoadInt64(&n.pending) >= int64(config.MaxPending) {
+		atomic.AddUint64(&n.shed, 1)
+		return false
+	}
+	atomic.AddInt64(&n.pending, 1)
+	select {
+	case n.requests <- m:
+		atomic.AddUint64(&n.admitted, 1)
+		return true
+	default:
+		atomic.AddInt64(&n.pending, -1)
+		atomic.AddUint64(&n.shed, 1)
+		return false
+	}
+}
+
+// done marks admitted request replied
+func (n *node) done() {
+	atomic.AddInt64(&n.pending, -1)
+}
+
+// fail replies error to all queued client requests
+func (n *node) fail(err error) {
+	for len(n.requests) > 0 {
+		switch r := (<-n.requests).(type) {
+		case Request:
+			r.Reply(Reply{Command: r.Command, Err: err})
+		case Transaction:
+			r.Reply(TransactionReply{Err: err})
+		}
+	}
+}
+
+// Load returns the admission state of node
+func (n *node) Load() Load {
+	return Load{
+		Messages: len(n.MessageChan),
+		Requests: len(n.requests),
+		Pending:  atomic.LoadInt64(&n.pending),
+		Admitted: atomic.LoadUint64(&n.admitted),
+		Shed:     atomic.LoadUint64(&n.shed),
+	}
+}
+
+// overloaded rejects http request with Retry-After header
+func overloaded(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(config.RetryAfter))
+	http.Error(w, ErrOverloaded.Error(), http.StatusServiceUnavailable)
+}
+
+// handleLoad serves GET /load with admission state of node
+func (n *node) handleLoad(w http.ResponseWriter, r *http.Request) {
+	err := json.NewEncoder(w).Encode(n.Load())
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,74 @@
+package paxi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAdmission(t *testing.T) {
+	n := &node{
+		id:          "1.1",
+		MessageChan: make(chan interface{}, 8),
+		admission:   admission{requests: make(chan interface{}, 1)},
+	}
+
+	// first request waits in queue, second is rejected
+	first := httptest.NewRecorder()
+	replied := make(chan bool)
+	go func() {
+		n.submit(first, httptest.NewRequest(http.MethodGet, "/a", nil), Request{Command: Command{Key: "a"}})
+		replied <- true
+	}()
+	for len(n.requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := httptest.NewRecorder()
+	n.submit(second, httptest.NewRequest(http.MethodGet, "/b", nil), Request{Command: Command{Key: "b"}})
+	if second.Code != http.StatusServiceUnavailable || second.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with Retry-After, got %d %v", second.Code, second.Header())
+	}
+	load := n.Load()
+	if load.Admitted != 1 || load.Shed != 1 || load.Pending != 1 {
+		t.Errorf("unexpected load %+v", load)
+	}
+
+	r := (<-n.requests).(Request)
+	r.Reply(Reply{Command: r.Command, Value: Value("v")})
+	<-replied
+	if first.Code != http.StatusOK || first.Body.String() != "v" {
+		t.Errorf("expected admitted request replied, got %d %s", first.Code, first.Body)
+	}
+	if n.Load().Pending != 0 {
+		t.Errorf("expected no pending request, got %+v", n.Load())
+	}
+}
+
+func TestAdmissionPriority(t *testing.T) {
+	n := &node{
+		id:          "1.1",
+		MessageChan: make(chan interface{}, 8),
+		admission:   admission{requests: make(chan interface{}, 8)},
+		handles:     make(map[string]reflect.Value),
+	}
+	var order []string
+	stop := make(chan struct{})
+	n.Register(Request{}, func(r Request) {
+		order = append(order, string(r.Command.Key))
+		if len(order) == 4 {
+			close(stop)
+		}
+	})
+	n.Register(ping{}, func(p ping) { order = append(order, "ping") })
+
+	n.admit(Request{Command: Command{Key: "a"}})
+	n.admit(Request{Command: Command{Key: "b"}})
+	n.MessageChan <- ping{N: 1}
+	n.MessageChan <- ping{N: 2}
+	n.handle(stop)
+	if len(order) != 4 || order[0] != "ping" || order[1] != "ping" {
+		t.Errorf("expected protocol messages handled before requests, got %v", order)
+	}
+}
@@ -0,0 +1,153 @@
+package paxi
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// Batch is a group of messages to the same peer sent as one message
+// Data holds the messages encoded in order by one codec of configuration
+type Batch struct {
+	Data []byte
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler for binary codec
+func (b Batch) MarshalBinary() ([]byte, error) {
+	return b.Data, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for binary codec
+func (b *Batch) UnmarshalBinary(data []byte) error {
+	b.Data = append([]byte(nil), data...)
+	return nil
+}
+
+// Messages decodes all messages of batch that is the first of its connection
+func (b Batch) Messages() ([]interface{}, error) {
+	return newUnbatcher().messages(b)
+}
+
+// batcher encodes all batches of one connection by one codec,
+// so that type information of messages is sent once per connection
+type batcher struct {
+	buf   bytes.Buffer
+	codec Codec
+}
+
+func newBatcher() *batcher {
+	b := new(batcher)
+	b.codec = newCodec(&b.buf)
+	return b
+}
+
+// add encodes message into current batch
+func (b *batcher) add(m interface{}) error {
+	size := b.buf.Len()
+	err := b.codec.Encode(&m)
+	if err != nil {
+		b.buf.Truncate(size)
+	}
+	return err
+}
+
+// flush returns current batch and starts the next one
+func (b *batcher) flush() Batch {
+	data := append([]byte(nil), b.buf.Bytes()...)
+	b.buf.Reset()
+	return Batch{Data: data}
+}
+
+// encode encodes messages as one batch, messages that cannot be encoded are left out
+func (b *batcher) encode(msgs []interface{}) Batch {
+	for _, m := range msgs {
+		b.add(m)
+	}
+	return b.flush()
+}
+
+// unbatcher decodes all batches of one connection by one codec
+type unbatcher struct {
+	buf   bytes.Buffer
+	codec Codec
+}
+
+func newUnbatcher() *unbatcher {
+	u := new(unbatcher)
+	u.codec = newCodec(&u.buf)
+	return u
+}
+
+// messages decodes all messages in batch
+func (u *unbatcher) messages(b Batch) ([]interface{}, error) {
+	u.buf.Write(b.Data)
+	msgs := make([]interface{}, 0)
+	for {
+		var m interface{}
+		err := u.codec.Decode(&m)
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, m)
+	}
+}
+
+// batch coalesces first message with following messages in send queue without waiting for more,
+// until queue is empty, batch size reaches byte budget or batch interval elapsed,
+// it returns the batch encoded by batcher of connection with its messages,
+// or first message as is if nothing else is queued
+func (t *transport) batch(first interface{}, b *batcher) (interface{}, []interface{}) {
+	if len(t.send) == 0 {
+		return first, nil
+	}
+	if err := b.add(first); err != nil {
+		// let connection report the error of message
+		return first, nil
+	}
+	msgs := []interface{}{first}
+	deadline := time.Now().Add(time.Duration(config.BatchInterval) * time.Microsecond)
+	for b.buf.Len() < config.BatchSize && time.Now().Before(deadline) {
+		var m interface{}
+		select {
+		case m = <-t.send:
+		default:
+			return b.flush(), msgs
+		}
+		if err := b.add(m); err != nil {
+			log.Errorf("drop message %+v to %s: %v", m, t.uri, err)
+			t.peer.dropped()
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	return b.flush(), msgs
+}
+
+// deliver puts received message into recv channel, batch is unpacked in order by unbatcher of connection
+func (t *transport) deliver(m interface{}, batches *unbatcher) {
+	b, ok := m.(Batch)
+	if !ok {
+		t.put(m)
+		return
+	}
+	msgs, err := batches.messages(b)
+	if err != nil {
+		log.Errorf("batch of %d messages broken: %v", len(msgs), err)
+	}
+	for _, m := range msgs {
+		t.put(m)
+	}
+}
+
+// put puts message into recv channel, message is discarded if transport is closed
+func (t *transport) put(m interface{}) {
+	select {
+	case t.recv <- m:
+	case <-t.close:
+	}
+}
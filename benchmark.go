@@ -3,6 +3,7 @@ package paxi
 import (
 	"math"
 	"math/rand"
+	"strconv"
 	"sync"
 	"time"
 
@@ -12,21 +13,29 @@ import (
 // DB is general interface implemented by client to call client library
 type DB interface {
 	Init() error
-	Read(key int) (int, error)
-	Write(key, value int) error
+	Read(key Key) (int, error)
+	Write(key Key, value int) error
 	Stop() error
 }
 
+// SessionDB is implemented by DB that opens a client session for every benchmark worker,
+// so that commands of one session are issued in order
+type SessionDB interface {
+	DB
+	Session() DB
+}
+
 // Bconfig holds all benchmark configuration
 type Bconfig struct {
-	T                    int     // total number of running time in seconds
-	N                    int     // total number of requests
-	K                    int     // key sapce
-	W                    float64 // write ratio
-	Throttle             int     // requests per second throttle, unused if 0
-	Concurrency          int     // number of simulated clients
-	Distribution         string  // distribution
-	LinearizabilityCheck bool    // run linearizability checker at the end of benchmark
+	T                    int       // total number of running time in seconds
+	N                    int       // total number of requests
+	K                    int       // key sapce
+	W                    float64   // write ratio
+	Throttle             int       // requests per second throttle, unused if 0
+	Concurrency          int       // number of simulated clients
+	Distribution         string    // distribution
+	LinearizabilityCheck bool      // run linearizability checker at the end of benchmark
+	Nemesis              []Nemesis // schedule of faults injected during benchmark run
 	// rounds       int    // repeat in many rounds sequentially
 
 	// conflict distribution
@@ -75,6 +84,7 @@ type Benchmark struct {
 	db DB // read/write operation interface
 	Bconfig
 	*History
+	Admin AdminClient // fault injection of nemesis schedule
 
 	rate      *Limiter
 	latency   []time.Duration // latency per operation
@@ -83,6 +93,9 @@ type Benchmark struct {
 	counter   int
 
 	wait sync.WaitGroup // waiting for all generated keys to complete
+
+	faults     []activeFault // faults injected by nemesis
+	faultsLock sync.RWMutex
 }
 
 // NewBenchmark returns new Benchmark object given implementation of DB interface
@@ -106,18 +119,18 @@ func (b *Benchmark) Load() {
 	b.Throttle = 0
 
 	b.db.Init()
-	keys := make(chan int, b.Concurrency)
+	keys := make(chan Key, b.Concurrency)
 	latencies := make(chan time.Duration, 1000)
 	defer close(latencies)
 	go b.collect(latencies)
 
 	b.startTime = time.Now()
 	for i := 0; i < b.Concurrency; i++ {
-		go b.worker(keys, latencies)
+		go b.worker(b.session(), keys, latencies)
 	}
 	for i := b.Min; i < b.Min+b.K; i++ {
 		b.wait.Add(1)
-		keys <- i
+		keys <- Key(strconv.Itoa(i))
 	}
 	t := time.Now().Sub(b.startTime)
 
@@ -141,17 +154,34 @@ func (b *Benchmark) Run() {
 	}
 
 	b.latency = make([]time.Duration, 0)
-	keys := make(chan int, b.Concurrency)
+	keys := make(chan Key, b.Concurrency)
 	latencies := make(chan time.Duration, 1000)
 	defer close(latencies)
 	go b.collect(latencies)
 
 	for i := 0; i < b.Concurrency; i++ {
-		go b.worker(keys, latencies)
+		go b.worker(b.session(), keys, latencies)
 	}
 
 	b.db.Init()
 	b.startTime = time.Now()
+	stopNemesis := func() {}
+	if len(b.Nemesis) > 0 {
+		if b.Admin == nil {
+			log.Warning("nemesis schedule is ignored without admin client")
+		} else {
+			done := make(chan struct{})
+			stopped := make(chan struct{})
+			go func() {
+				b.nemesis(done)
+				close(stopped)
+			}()
+			stopNemesis = func() {
+				close(done)
+				<-stopped
+			}
+		}
+	}
 	if b.T > 0 {
 		timer := time.NewTimer(time.Second * time.Duration(b.T))
 	loop:
@@ -172,22 +202,28 @@ func (b *Benchmark) Run() {
 		b.wait.Wait()
 	}
 	t := time.Now().Sub(b.startTime)
+	stopNemesis()
 
-	b.db.Stop()
+	// wait for operations in flight
 	close(keys)
+	b.wait.Wait()
+	b.db.Stop()
 	stat := Statistic(b.latency)
 	log.Infof("Concurrency = %d", b.Concurrency)
 	log.Infof("Write Ratio = %f", b.W)
 	log.Infof("Number of Keys = %d", b.K)
 	log.Infof("Benchmark Time = %v\n", t)
 	log.Infof("Throughput = %f\n", float64(len(b.latency))/t.Seconds())
+	log.Infof("Longest unavailability = %v", b.History.Unavailability())
 	log.Info(stat)
 
 	stat.WriteFile("latency")
 	b.History.WriteFile("history")
 
+	var anomalies []*operation
 	if b.LinearizabilityCheck {
-		n := b.History.Linearizable()
+		anomalies = b.History.anomalies()
+		n := len(anomalies)
 		if n == 0 {
 			log.Info("The execution is linearizable.")
 		} else {
@@ -196,10 +232,13 @@ func (b *Benchmark) Run() {
 			log.Infof("Anomaly percentage is %f", float64(n)/float64(stat.Size))
 		}
 	}
+	if len(b.faults) > 0 {
+		faultSummary(b.History, anomalies)
+	}
 }
 
 // generates key based on distribution
-func (b *Benchmark) next() int {
+func (b *Benchmark) next() Key {
 	var key int
 	switch b.Distribution {
 	case "order":
@@ -240,10 +279,18 @@ func (b *Benchmark) next() int {
 		b.rate.Wait()
 	}
 
-	return key
+	return Key(strconv.Itoa(key))
 }
 
-func (b *Benchmark) worker(keys <-chan int, result chan<- time.Duration) {
+// session returns DB of one benchmark worker
+func (b *Benchmark) session() DB {
+	if s, ok := b.db.(SessionDB); ok {
+		return s.Session()
+	}
+	return b.db
+}
+
+func (b *Benchmark) worker(db DB, keys <-chan Key, result chan<- time.Duration) {
 	var s time.Time
 	var e time.Time
 	var v int
@@ -253,24 +300,29 @@ func (b *Benchmark) worker(keys <-chan int, result chan<- time.Duration) {
 		if rand.Float64() < b.W {
 			v = rand.Int()
 			s = time.Now()
-			err = b.db.Write(k, v)
+			err = db.Write(k, v)
 			e = time.Now()
 			op.input = v
 		} else {
 			s = time.Now()
-			v, err = b.db.Read(k)
+			v, err = db.Read(k)
 			e = time.Now()
 			op.output = v
 		}
 		op.start = s.Sub(b.startTime).Nanoseconds()
 		if err == nil {
 			op.end = e.Sub(b.startTime).Nanoseconds()
-			result <- e.Sub(s)
 		} else {
 			op.end = math.MaxInt64
 			log.Error(err)
 		}
 		b.History.AddOperation(k, op)
+		b.History.tag(op, b.activeFaults(op.start, e.Sub(b.startTime).Nanoseconds()))
+		if err == nil {
+			result <- e.Sub(s)
+		} else {
+			b.wait.Done()
+		}
 	}
 }
 
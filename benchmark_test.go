@@ -1,6 +1,7 @@
 package paxi
 
 import (
+	"strconv"
 	"sync"
 	"testing"
 
@@ -25,8 +26,9 @@ func (f *FakeDB) Stop() error {
 	return nil
 }
 
-func (f *FakeDB) Read(key int) (int, error) {
-	//log.Debugf("Read %d", key)
+func (f *FakeDB) Read(k Key) (int, error) {
+	//log.Debugf("Read %v", k)
+	key, _ := strconv.Atoi(string(k))
 	f.lock.Lock()
 	f.total++
 	if key >= f.start && key <= f.end {
@@ -37,8 +39,9 @@ func (f *FakeDB) Read(key int) (int, error) {
 	return 0, nil
 }
 
-func (f *FakeDB) Write(key, value int) error {
-	//log.Debugf("Write %d", key)
+func (f *FakeDB) Write(k Key, value int) error {
+	//log.Debugf("Write %v", k)
+	key, _ := strconv.Atoi(string(k))
 	f.lock.Lock()
 	f.total++
 	if key >= f.start && key <= f.end {
@@ -0,0 +1,335 @@
+package paxi
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// binary codec frames every message as [length uint32][type id uint16][payload]
+// message type registered by RegisterMessage is encoded by its own MarshalBinary method,
+// any other message is gob encoded with type id 0, as one gob stream per codec
+// so that type descriptors are sent only once per connection
+
+var errShortBuffer = errors.New("binary message too short")
+
+// maxFrameSize bounds the payload of a binary frame to guard against corrupted length
+const maxFrameSize = 64 << 20
+
+var messages = struct {
+	sync.RWMutex
+	ids   map[reflect.Type]uint16
+	types map[uint16]reflect.Type
+}{
+	ids:   make(map[reflect.Type]uint16),
+	types: make(map[uint16]reflect.Type),
+}
+
+// RegisterMessage registers message type with unique type id used by binary codec, id 0 is reserved
+// message value must implement encoding.BinaryMarshaler and its pointer encoding.BinaryUnmarshaler
+func RegisterMessage(id uint16, m interface{}) {
+	t := reflect.TypeOf(m)
+	if _, ok := m.(encoding.BinaryMarshaler); !ok {
+		panic(fmt.Sprintf("message %v does not implement encoding.BinaryMarshaler", t))
+	}
+	if _, ok := reflect.New(t).Interface().(encoding.BinaryUnmarshaler); !ok {
+		panic(fmt.Sprintf("message %v does not implement encoding.BinaryUnmarshaler", t))
+	}
+	messages.Lock()
+	defer messages.Unlock()
+	if id == 0 {
+		panic("message type id 0 is reserved")
+	}
+	if r, exists := messages.types[id]; exists && r != t {
+		panic(fmt.Sprintf("message type id %d registered by both %v and %v", id, r, t))
+	}
+	messages.ids[t] = id
+	messages.types[id] = t
+}
+
+type codecBinary struct {
+	w      io.Writer
+	r      io.Reader
+	header [6]byte
+
+	encoder *gob.Encoder
+	encoded bytes.Buffer // gob output of current frame
+	decoder *gob.Decoder
+	decoded bytes.Buffer // gob input of current frame
+}
+
+func (c *codecBinary) Scheme() string {
+	return "binary"
+}
+
+// Encode writes message m, or the message pointed by m, as one frame
+func (c *codecBinary) Encode(m interface{}) error {
+	if p, ok := m.(*interface{}); ok {
+		m = *p
+	}
+	messages.RLock()
+	id := messages.ids[reflect.TypeOf(m)]
+	messages.RUnlock()
+
+	var payload []byte
+	var err error
+	if id > 0 {
+		payload, err = m.(encoding.BinaryMarshaler).MarshalBinary()
+	} else {
+		if c.encoder == nil {
+			c.encoder = gob.NewEncoder(&c.encoded)
+		}
+		c.encoded.Reset()
+		err = c.encoder.Encode(&m)
+		payload = c.encoded.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 6+len(payload))
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint16(frame[4:6], id)
+	copy(frame[6:], payload)
+	_, err = c.w.Write(frame)
+	return err
+}
+
+// Decode reads next frame into m, m should be pointer to interface{} or to the message type
+func (c *codecBinary) Decode(m interface{}) error {
+	if _, err := io.ReadFull(c.r, c.header[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(c.header[:4])
+	id := binary.BigEndian.Uint16(c.header[4:6])
+	if size > maxFrameSize {
+		return fmt.Errorf("binary frame of %d bytes exceeds limit", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	var msg interface{}
+	if id == 0 {
+		if c.decoder == nil {
+			c.decoder = gob.NewDecoder(&c.decoded)
+		}
+		c.decoded.Reset()
+		c.decoded.Write(payload)
+		if err := c.decoder.Decode(&msg); err != nil {
+			return err
+		}
+	} else {
+		messages.RLock()
+		t, exists := messages.types[id]
+		messages.RUnlock()
+		if !exists {
+			return fmt.Errorf("unknown message type id %d", id)
+		}
+		v := reflect.New(t)
+		if err := v.Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(payload); err != nil {
+			return err
+		}
+		msg = v.Elem().Interface()
+	}
+
+	target := reflect.ValueOf(m)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("binary codec decodes into non-pointer")
+	}
+	v := reflect.ValueOf(msg)
+	if !v.Type().AssignableTo(target.Elem().Type()) {
+		return fmt.Errorf("cannot decode %v into %v", v.Type(), target.Elem().Type())
+	}
+	target.Elem().Set(v)
+	return nil
+}
+
+// BinaryWriter appends values in the compact encoding used by MarshalBinary of hot messages
+// integers are varint encoded, strings and byte slices are length prefixed
+type BinaryWriter struct {
+	buf []byte
+	tmp [binary.MaxVarintLen64]byte
+}
+
+// Data returns encoded bytes
+func (w *BinaryWriter) Data() []byte {
+	return w.buf
+}
+
+// PutInt appends signed integer
+func (w *BinaryWriter) PutInt(i int64) {
+	n := binary.PutVarint(w.tmp[:], i)
+	w.buf = append(w.buf, w.tmp[:n]...)
+}
+
+// PutUint appends unsigned integer
+func (w *BinaryWriter) PutUint(i uint64) {
+	n := binary.PutUvarint(w.tmp[:], i)
+	w.buf = append(w.buf, w.tmp[:n]...)
+}
+
+// PutString appends length prefixed string
+func (w *BinaryWriter) PutString(s string) {
+	w.PutUint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// PutBytes appends length prefixed bytes, nil and empty slices are distinguished
+func (w *BinaryWriter) PutBytes(b []byte) {
+	if b == nil {
+		w.PutUint(0)
+		return
+	}
+	w.PutUint(uint64(len(b)) + 1)
+	w.buf = append(w.buf, b...)
+}
+
+// PutBallot appends ballot number
+func (w *BinaryWriter) PutBallot(b Ballot) {
+	w.PutUint(uint64(b))
+}
+
+// PutCommand appends all fields of command
+func (w *BinaryWriter) PutCommand(c Command) {
+	w.PutString(string(c.Key))
+	w.PutBytes(c.Value)
+	w.PutString(string(c.ClientID))
+	w.PutInt(int64(c.CommandID))
+	w.PutInt(c.Session)
+	w.PutInt(int64(c.Op))
+	w.PutBytes(c.Expect)
+	w.PutInt(int64(c.TTL))
+	w.PutInt(c.Timestamp)
+}
+
+// PutIDInts appends map of node id to integer
+func (w *BinaryWriter) PutIDInts(m map[ID]int) {
+	w.PutUint(uint64(len(m)))
+	for id, i := range m {
+		w.PutString(string(id))
+		w.PutInt(int64(i))
+	}
+}
+
+// BinaryReader reads values written by BinaryWriter
+// the first error is kept and all following reads return zero values
+type BinaryReader struct {
+	buf []byte
+	err error
+}
+
+// NewBinaryReader creates reader of encoded data
+func NewBinaryReader(data []byte) *BinaryReader {
+	return &BinaryReader{buf: data}
+}
+
+// Err returns the first error of reads
+func (r *BinaryReader) Err() error {
+	return r.err
+}
+
+// Int reads signed integer
+func (r *BinaryReader) Int() int64 {
+	if r.err != nil {
+		return 0
+	}
+	i, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = errShortBuffer
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return i
+}
+
+// Uint reads unsigned integer
+func (r *BinaryReader) Uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	i, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errShortBuffer
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return i
+}
+
+func (r *BinaryReader) next(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if uint64(len(r.buf)) < n {
+		r.err = errShortBuffer
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+// String reads length prefixed string
+func (r *BinaryReader) String() string {
+	return string(r.next(r.Uint()))
+}
+
+// Bytes reads length prefixed bytes
+func (r *BinaryReader) Bytes() []byte {
+	n := r.Uint()
+	if n == 0 || r.err != nil {
+		return nil
+	}
+	b := r.next(n - 1)
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// Ballot reads ballot number
+func (r *BinaryReader) Ballot() Ballot {
+	return Ballot(r.Uint())
+}
+
+// Command reads all fields of command
+func (r *BinaryReader) Command() Command {
+	return Command{
+		Key:       Key(r.String()),
+		Value:     r.Bytes(),
+		ClientID:  ID(r.String()),
+		CommandID: int(r.Int()),
+		Session:   r.Int(),
+		Op:        Operation(r.Int()),
+		Expect:    r.Bytes(),
+		TTL:       time.Duration(r.Int()),
+		Timestamp: r.Int(),
+	}
+}
+
+// IDInts reads map of node id to integer
+func (r *BinaryReader) IDInts() map[ID]int {
+	n := r.Uint()
+	if r.err != nil || n > uint64(len(r.buf)) {
+		r.err = errShortBuffer
+		return nil
+	}
+	m := make(map[ID]int, n)
+	for i := uint64(0); i < n; i++ {
+		id := ID(r.String())
+		m[id] = int(r.Int())
+	}
+	return m
+}
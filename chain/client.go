@@ -28,13 +28,29 @@ func NewClient() *Client {
 	return c
 }
 
+// NewSession returns client sharing connections of c in a new session
+func (c *Client) NewSession() *Client {
+	return &Client{
+		HTTPClient: c.HTTPClient.NewSession(),
+		head:       c.head,
+		tail:       c.tail,
+	}
+}
+
 func (c *Client) Get(key paxi.Key) (paxi.Value, error) {
 	v, _, err := c.HTTPClient.RESTGet(c.tail, key)
 	return v, err
 }
 
 func (c *Client) Put(key paxi.Key, value paxi.Value) error {
-	c.HTTPClient.CID++
 	_, _, err := c.RESTPut(c.head, key, value)
 	return err
 }
+
+func (c *Client) Txn(cmds []paxi.Command) ([]paxi.Value, error) {
+	return c.HTTPClient.RESTTxn(c.head, cmds)
+}
+
+func (c *Client) Scan(from, to paxi.Key, limit int) ([]paxi.KeyValue, error) {
+	return c.HTTPClient.RESTScan(c.tail, from, to, limit)
+}
@@ -66,10 +66,11 @@ func NewReplica(id paxi.ID) *Replica {
 
 func (r *Replica) handleRequest(m paxi.Request) {
 	if m.Command.IsRead() && r.tail == r.ID() {
-		v := r.Node.Execute(m.Command)
+		v, err := r.Node.Execute(m.Command)
 		m.Reply(paxi.Reply{
 			Command: m.Command,
 			Value:   v,
+			Err:     err,
 		})
 		return
 	}
@@ -134,11 +135,13 @@ func (r *Replica) handleAck(m Ack) {
 
 	for r.log[r.clsn] != nil && r.log[r.clsn].ack {
 		e := r.log[r.clsn]
-		r.Node.Execute(e.command)
+		v, err := r.Node.Execute(e.command)
 
 		if r.head == r.ID() && e.request != nil {
 			e.request.Reply(paxi.Reply{
 				Command: e.command,
+				Value:   v,
+				Err:     err,
 			})
 			e.request = nil
 		}
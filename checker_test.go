@@ -1,6 +1,9 @@
 package paxi
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 // examples from https://pdos.csail.mit.edu/6.824/papers/fb-consistency.pdf
 func TestLinerizabilityChecker(t *testing.T) {
@@ -134,3 +137,15 @@ func TestNonUniqueValue(t *testing.T) {
 		t.Errorf("expected no violation, detected %d", n)
 	}
 }
+
+func TestUnavailability(t *testing.T) {
+	h := NewHistory()
+	h.Add("k", 1, nil, 0, 10)
+	h.Add("k", nil, 1, 5, 20)
+	h.Add("k", 2, nil, 15, math.MaxInt64) // failed
+	h.Add("k", nil, 2, 30, 120)
+	h.Add("k", 3, nil, 110, 130)
+	if d := h.Unavailability(); d != 100 {
+		t.Errorf("expected longest gap of 100ns, got %v", d)
+	}
+}
@@ -2,6 +2,7 @@ package paxi
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io"
@@ -9,8 +10,11 @@ import (
 	"math/rand"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ailidani/paxi/lib"
 	"github.com/ailidani/paxi/log"
@@ -20,6 +24,8 @@ import (
 type Client interface {
 	Get(Key) (Value, error)
 	Put(Key, Value) error
+	Scan(from, to Key, limit int) ([]KeyValue, error)
+	Txn([]Command) ([]Value, error)
 }
 
 // AdminClient interface provides fault injection opeartion
@@ -27,7 +33,14 @@ type AdminClient interface {
 	Consensus(Key) bool
 	Crash(ID, int)
 	Drop(ID, ID, int)
+	Slow(ID, ID, int, int)
+	Flaky(ID, ID, float64, int)
+	Duplicate(ID, ID, float64, int)
+	Reorder(ID, ID, float64, int)
 	Partition(int, ...ID)
+	PartitionOneWay(int, []ID, []ID)
+	Faults(ID) ([]Fault, error)
+	Heal(...ID)
 }
 
 // HTTPClient inplements Client interface with REST API
@@ -38,18 +51,35 @@ type HTTPClient struct {
 	N      int // total number of nodes
 	LocalN int // number of nodes in local zone
 
-	CID int // command id
+	CID     int64 // last command id, accessed atomically
+	Session int64 // client session of command ids
 	*http.Client
 }
 
+// sessionSeq keeps sessions of clients created at the same time apart
+var sessionSeq int64
+
+// newSession returns a session number unique to the process and unlikely reused by restarted client
+func newSession() int64 {
+	return time.Now().UnixNano() + atomic.AddInt64(&sessionSeq, 1)
+}
+
 // NewHTTPClient creates a new Client from config
 func NewHTTPClient(id ID) *HTTPClient {
 	c := &HTTPClient{
-		ID:     id,
-		N:      len(config.Addrs),
-		Addrs:  config.Addrs,
-		HTTP:   config.HTTPAddrs,
-		Client: &http.Client{},
+		ID:      id,
+		N:       len(config.Addrs),
+		Addrs:   config.Addrs,
+		HTTP:    config.HTTPAddrs,
+		Client:  &http.Client{},
+		Session: newSession(),
+	}
+	if config.TLSCA != "" {
+		tlsConfig, err := tlsConfig(id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.Client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
 	}
 	if id != "" {
 		i := 0
@@ -64,10 +94,45 @@ func NewHTTPClient(id ID) *HTTPClient {
 	return c
 }
 
+// NewSession returns client sharing connections of c in a new session,
+// commands of one session should not be issued concurrently so that replicas see them in order
+func (c *HTTPClient) NewSession() *HTTPClient {
+	s := *c
+	s.CID = 0
+	s.Session = newSession()
+	return &s
+}
+
+// next returns the next command id of session
+func (c *HTTPClient) next() int {
+	return int(atomic.AddInt64(&c.CID, 1))
+}
+
+// session sets client, command and session id headers of request
+func (c *HTTPClient) session(req *http.Request) {
+	req.Header.Set(HTTPClientID, string(c.ID))
+	req.Header.Set(HTTPCommandID, strconv.Itoa(c.next()))
+	req.Header.Set(HTTPSession, strconv.FormatInt(c.Session, 10))
+}
+
+// UseCertificate sets client certificate and private key presented to https servers
+func (c *HTTPClient) UseCertificate(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t, ok := c.Client.Transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+		tlsConfig = t.TLSClientConfig.Clone()
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	c.Client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	return nil
+}
+
 // Get gets value of given key (use REST)
 // Default implementation of Client interface
 func (c *HTTPClient) Get(key Key) (Value, error) {
-	c.CID++
 	v, _, err := c.RESTGet(c.ID, key)
 	return v, err
 }
@@ -75,12 +140,17 @@ func (c *HTTPClient) Get(key Key) (Value, error) {
 // Put puts new key value pair and return previous value (use REST)
 // Default implementation of Client interface
 func (c *HTTPClient) Put(key Key, value Value) error {
-	c.CID++
 	_, _, err := c.RESTPut(c.ID, key, value)
 	return err
 }
 
+// GetURL returns url of key in node id, or in a random node if id is empty
 func (c *HTTPClient) GetURL(id ID, key Key) string {
+	return c.url(id, KeyPath+url.PathEscape(string(key)))
+}
+
+// url returns url of path in node id, or in a random node if id is empty
+func (c *HTTPClient) url(id ID, path string) string {
 	if id == "" {
 		for id = range c.HTTP {
 			if c.ID == "" || id.Zone() == c.ID.Zone() {
@@ -96,28 +166,162 @@ func (c *HTTPClient) GetURL(id ID, key Key) string {
 			i--
 		}
 	}
-	return c.HTTP[id] + "/" + strconv.Itoa(int(key))
+	return c.HTTP[id] + path
+}
+
+// Scan reads key-value pairs in range [from, to) in order with at most limit results
+// range is unbounded above if to is empty, and results are unlimited if limit is not positive
+func (c *HTTPClient) Scan(from, to Key, limit int) ([]KeyValue, error) {
+	return c.RESTScan(c.ID, from, to, limit)
+}
+
+// Txn executes all commands atomically in order and returns the result of every command
+func (c *HTTPClient) Txn(cmds []Command) ([]Value, error) {
+	return c.RESTTxn(c.ID, cmds)
+}
+
+// Watch streams every executed write of key, or every key starting with key if prefix is true,
+// the channel is closed when stream ends or stop is called
+func (c *HTTPClient) Watch(key Key, prefix bool) (<-chan Event, func(), error) {
+	q := url.Values{}
+	q.Set("key", string(key))
+	q.Set("prefix", strconv.FormatBool(prefix))
+	req, err := http.NewRequest(http.MethodGet, c.url(c.ID, "/watch")+"?"+q.Encode(), nil)
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
+	req.Header.Set(HTTPClientID, string(c.ID))
+	rep, err := c.Client.Do(req)
+	if err != nil {
+		log.Error(err)
+		return nil, nil, err
+	}
+	if rep.StatusCode != http.StatusOK {
+		rep.Body.Close()
+		return nil, nil, errors.New(rep.Status)
+	}
+
+	events := make(chan Event)
+	done := make(chan struct{})
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(done)
+			rep.Body.Close()
+		})
+	}
+	go func() {
+		defer close(events)
+		defer stop()
+		decoder := json.NewDecoder(rep.Body)
+		for {
+			var e Event
+			if err := decoder.Decode(&e); err != nil {
+				return
+			}
+			select {
+			case events <- e:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return events, stop, nil
+}
+
+// PutTTL puts new key value pair that expires after ttl
+func (c *HTTPClient) PutTTL(key Key, value Value, ttl time.Duration) error {
+	_, _, err := c.do(c.ID, Command{Key: key, Value: value, TTL: ttl})
+	return err
+}
+
+// TTL returns value of key with its remaining time to live, 0 if key never expires
+func (c *HTTPClient) TTL(key Key) (Value, time.Duration, error) {
+	v, meta, err := c.RESTGet(c.ID, key)
+	if err != nil || meta[HTTPTTL] == "" {
+		return v, 0, err
+	}
+	ttl, err := time.ParseDuration(meta[HTTPTTL])
+	return v, ttl, err
+}
+
+// Delete removes the key and returns its previous value
+func (c *HTTPClient) Delete(key Key) (Value, error) {
+	v, _, err := c.do(c.ID, Command{Key: key, Op: OpDelete})
+	return v, err
+}
+
+// CAS writes value to key only if its current value equals expect, returns ErrCASFailed otherwise
+// empty expect requires the key not exists, and nil value deletes the key
+func (c *HTTPClient) CAS(key Key, expect, value Value) error {
+	_, _, err := c.do(c.ID, Command{Key: key, Value: value, Op: OpCAS, Expect: expect})
+	return err
+}
+
+// Increment adds delta to the integer value of key and returns the new value
+func (c *HTTPClient) Increment(key Key, delta int64) (int64, error) {
+	v, _, err := c.do(c.ID, Command{Key: key, Value: Value(strconv.FormatInt(delta, 10)), Op: OpIncrement})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(v), 10, 64)
+}
+
+// Append appends value to key and returns the new value
+func (c *HTTPClient) Append(key Key, value Value) (Value, error) {
+	v, _, err := c.do(c.ID, Command{Key: key, Value: value, Op: OpAppend})
+	return v, err
 }
 
 // rest accesses server's REST API with url = http://ip:port/key
 // if value == nil, it's a read
 func (c *HTTPClient) rest(id ID, key Key, value Value) (Value, map[string]string, error) {
+	return c.do(id, Command{Key: key, Value: value})
+}
+
+// do sends command to server's REST API, the operation is mapped to http method, query and conditional headers
+func (c *HTTPClient) do(id ID, cmd Command) (Value, map[string]string, error) {
 	// get url
-	url := c.GetURL(id, key)
+	url := c.GetURL(id, cmd.Key)
 
 	method := http.MethodGet
 	var body io.Reader
-	if value != nil {
+	switch cmd.Operation() {
+	case OpPut, OpCAS:
+		method = http.MethodPut
+		body = bytes.NewBuffer(cmd.Value)
+		if cmd.Value == nil {
+			method = http.MethodDelete
+			body = nil
+		}
+	case OpDelete:
+		method = http.MethodDelete
+	case OpIncrement:
+		method = http.MethodPut
+		body = bytes.NewBuffer(cmd.Value)
+		url += "?op=increment"
+	case OpAppend:
 		method = http.MethodPut
-		body = bytes.NewBuffer(value)
+		body = bytes.NewBuffer(cmd.Value)
+		url += "?op=append"
 	}
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		log.Error(err)
 		return nil, nil, err
 	}
-	req.Header.Set(HTTPClientID, string(c.ID))
-	req.Header.Set(HTTPCommandID, strconv.Itoa(c.CID))
+	if cmd.TTL > 0 {
+		req.Header.Set(HTTPTTL, cmd.TTL.String())
+	}
+	if cmd.Operation() == OpCAS {
+		if len(cmd.Expect) > 0 {
+			req.Header.Set(HTTPIfMatch, string(cmd.Expect))
+		} else {
+			req.Header.Set(HTTPIfNoneMatch, "*")
+		}
+	}
+	c.session(req)
 	// r.Header.Set(HTTPTimestamp, strconv.FormatInt(time.Now().UnixNano(), 10))
 
 	rep, err := c.Client.Do(req)
@@ -139,14 +343,18 @@ func (c *HTTPClient) rest(id ID, key Key, value Value) (Value, map[string]string
 			log.Error(err)
 			return nil, metadata, err
 		}
-		if value == nil {
-			log.Debugf("node=%v type=%s key=%v value=%x", id, method, key, Value(b))
+		if cmd.Value == nil {
+			log.Debugf("node=%v type=%s key=%v value=%x", id, method, cmd.Key, Value(b))
 		} else {
-			log.Debugf("node=%v type=%s key=%v value=%x", id, method, key, value)
+			log.Debugf("node=%v type=%s key=%v value=%x", id, method, cmd.Key, cmd.Value)
 		}
 		return Value(b), metadata, nil
 	}
 
+	if rep.StatusCode == http.StatusPreconditionFailed {
+		return nil, metadata, ErrCASFailed
+	}
+
 	// http call failed
 	dump, _ := httputil.DumpResponse(rep, true)
 	log.Debugf("%q", dump)
@@ -163,13 +371,73 @@ func (c *HTTPClient) RESTPut(id ID, key Key, value Value) (Value, map[string]str
 	return c.rest(id, key, value)
 }
 
+// RESTScan issues a scan http call to node
+func (c *HTTPClient) RESTScan(id ID, from, to Key, limit int) ([]KeyValue, error) {
+	q := url.Values{}
+	q.Set("from", string(from))
+	q.Set("to", string(to))
+	q.Set("limit", strconv.Itoa(limit))
+	req, err := http.NewRequest(http.MethodGet, c.url(id, "/scan")+"?"+q.Encode(), nil)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	c.session(req)
+	rep, err := c.Client.Do(req)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	defer rep.Body.Close()
+	if rep.StatusCode != http.StatusOK {
+		dump, _ := httputil.DumpResponse(rep, true)
+		log.Debugf("%q", dump)
+		return nil, errors.New(rep.Status)
+	}
+	kvs := make([]KeyValue, 0)
+	err = json.NewDecoder(rep.Body).Decode(&kvs)
+	return kvs, err
+}
+
+// RESTTxn issues a transaction http call to node
+func (c *HTTPClient) RESTTxn(id ID, cmds []Command) ([]Value, error) {
+	body, err := json.Marshal(cmds)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url(id, "/txn"), bytes.NewBuffer(body))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	c.session(req)
+	rep, err := c.Client.Do(req)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	defer rep.Body.Close()
+	if rep.StatusCode == http.StatusPreconditionFailed {
+		return nil, ErrCASFailed
+	}
+	if rep.StatusCode != http.StatusOK {
+		dump, _ := httputil.DumpResponse(rep, true)
+		log.Debugf("%q", dump)
+		return nil, errors.New(rep.Status)
+	}
+	values := make([]Value, 0)
+	err = json.NewDecoder(rep.Body).Decode(&values)
+	return values, err
+}
+
 func (c *HTTPClient) json(id ID, key Key, value Value) (Value, error) {
 	url := c.HTTP[id]
 	cmd := Command{
 		Key:       key,
 		Value:     value,
 		ClientID:  c.ID,
-		CommandID: c.CID,
+		CommandID: c.next(),
+		Session:   c.Session,
 	}
 	data, err := json.Marshal(cmd)
 	res, err := c.Client.Post(url, "json", bytes.NewBuffer(data))
@@ -287,9 +555,9 @@ func (c *HTTPClient) QuorumPut(key Key, value Value) {
 // Consensus collects /history/key from every node and compare their values
 func (c *HTTPClient) Consensus(k Key) bool {
 	h := make(map[ID][]Value)
-	for id, url := range c.HTTP {
+	for id, addr := range c.HTTP {
 		h[id] = make([]Value, 0)
-		r, err := c.Client.Get(url + "/history?key=" + strconv.Itoa(int(k)))
+		r, err := c.Client.Get(addr + "/history?key=" + url.QueryEscape(string(k)))
 		if err != nil {
 			log.Error(err)
 			continue
@@ -331,37 +599,104 @@ func (c *HTTPClient) Consensus(k Key) bool {
 // Crash stops the node for t seconds then recover
 // node crash forever if t < 0
 func (c *HTTPClient) Crash(id ID, t int) {
-	url := c.HTTP[id] + "/crash?t=" + strconv.Itoa(t)
-	r, err := c.Client.Get(url)
-	if err != nil {
-		log.Error(err)
-		return
-	}
-	r.Body.Close()
+	c.fault(id, FaultCrash, url.Values{"t": {strconv.Itoa(t)}})
 }
 
 // Drop drops every message send for t seconds
 func (c *HTTPClient) Drop(from, to ID, t int) {
-	url := c.HTTP[from] + "/drop?id=" + string(to) + "&t=" + strconv.Itoa(t)
-	r, err := c.Client.Get(url)
+	c.fault(from, FaultDrop, url.Values{"id": {string(to)}, "t": {strconv.Itoa(t)}})
+}
+
+// Slow delays every message send for d ms for t seconds
+func (c *HTTPClient) Slow(from, to ID, d int, t int) {
+	c.fault(from, FaultSlow, url.Values{"id": {string(to)}, "d": {strconv.Itoa(d)}, "t": {strconv.Itoa(t)}})
+}
+
+// Flaky drops message send by chance p for t seconds
+func (c *HTTPClient) Flaky(from, to ID, p float64, t int) {
+	c.fault(from, FaultFlaky, url.Values{"id": {string(to)}, "p": {strconv.FormatFloat(p, 'f', -1, 64)}, "t": {strconv.Itoa(t)}})
+}
+
+// Duplicate sends message twice by chance p for t seconds
+func (c *HTTPClient) Duplicate(from, to ID, p float64, t int) {
+	c.fault(from, FaultDuplicate, url.Values{"id": {string(to)}, "p": {strconv.FormatFloat(p, 'f', -1, 64)}, "t": {strconv.Itoa(t)}})
+}
+
+// Reorder holds back message send by chance p so later messages overtake it, for t seconds
+func (c *HTTPClient) Reorder(from, to ID, p float64, t int) {
+	c.fault(from, FaultReorder, url.Values{"id": {string(to)}, "p": {strconv.FormatFloat(p, 'f', -1, 64)}, "t": {strconv.Itoa(t)}})
+}
+
+// fault injects fault of type into node id
+func (c *HTTPClient) fault(id ID, fault string, q url.Values) {
+	r, err := c.Client.Get(c.HTTP[id] + "/" + fault + "?" + q.Encode())
 	if err != nil {
 		log.Error(err)
 		return
 	}
+	if r.StatusCode != http.StatusOK {
+		log.Errorf("node %s rejected %s fault: %s", id, fault, r.Status)
+	}
 	r.Body.Close()
 }
 
-// Partition cuts the network between nodes for t seconds
+// Partition cuts the network between given nodes and the rest for t seconds in both directions
 func (c *HTTPClient) Partition(t int, nodes ...ID) {
 	s := lib.NewSet()
 	for _, id := range nodes {
 		s.Add(id)
 	}
-	for from := range c.Addrs {
-		if !s.Has(from) {
-			for _, to := range nodes {
-				c.Drop(from, to, t)
-			}
+	others := make([]ID, 0)
+	for id := range c.Addrs {
+		if !s.Has(id) {
+			others = append(others, id)
+		}
+	}
+	c.PartitionOneWay(t, others, nodes)
+	c.PartitionOneWay(t, nodes, others)
+}
+
+// PartitionOneWay drops every message from nodes to other nodes for t seconds,
+// messages in the other direction are delivered,
+// every sender node gets one partition fault listed and healed as one unit
+func (c *HTTPClient) PartitionOneWay(t int, from, to []ID) {
+	ids := make([]string, 0, len(to))
+	for _, id := range to {
+		ids = append(ids, string(id))
+	}
+	for _, f := range from {
+		c.fault(f, FaultPartition, url.Values{"id": ids, "t": {strconv.Itoa(t)}})
+	}
+}
+
+// Faults returns active faults of node
+func (c *HTTPClient) Faults(id ID) ([]Fault, error) {
+	r, err := c.Client.Get(c.HTTP[id] + "/faults")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return nil, errors.New(r.Status)
+	}
+	faults := make([]Fault, 0)
+	err = json.NewDecoder(r.Body).Decode(&faults)
+	return faults, err
+}
+
+// Heal removes all faults of given nodes, or every node if none is given
+func (c *HTTPClient) Heal(nodes ...ID) {
+	if len(nodes) == 0 {
+		for id := range c.HTTP {
+			nodes = append(nodes, id)
+		}
+	}
+	for _, id := range nodes {
+		r, err := c.Client.Get(c.HTTP[id] + "/heal")
+		if err != nil {
+			log.Error(err)
+			continue
 		}
+		r.Body.Close()
 	}
 }
@@ -17,6 +17,12 @@ var master = flag.String("master", "", "Master address.")
 // db implements Paxi.DB interface for benchmarking
 type db struct {
 	paxi.Client
+	session func() paxi.Client // opens new session of client
+}
+
+// Session implements paxi.SessionDB interface
+func (d *db) Session() paxi.DB {
+	return &db{Client: d.session(), session: d.session}
 }
 
 func (d *db) Init() error {
@@ -27,8 +33,7 @@ func (d *db) Stop() error {
 	return nil
 }
 
-func (d *db) Read(k int) (int, error) {
-	key := paxi.Key(k)
+func (d *db) Read(key paxi.Key) (int, error) {
 	v, err := d.Get(key)
 	if len(v) == 0 {
 		return 0, nil
@@ -37,8 +42,7 @@ func (d *db) Read(k int) (int, error) {
 	return int(x), err
 }
 
-func (d *db) Write(k, v int) error {
-	key := paxi.Key(k)
+func (d *db) Write(key paxi.Key, v int) error {
 	value := make([]byte, binary.MaxVarintLen64)
 	binary.PutUvarint(value, uint64(v))
 	err := d.Put(key, value)
@@ -55,14 +59,21 @@ func main() {
 	d := new(db)
 	switch *algorithm {
 	case "paxos":
-		d.Client = paxos.NewClient(paxi.ID(*id))
+		c := paxos.NewClient(paxi.ID(*id))
+		d.Client = c
+		d.session = func() paxi.Client { return c.NewSession() }
 	case "chain":
-		d.Client = chain.NewClient()
+		c := chain.NewClient()
+		d.Client = c
+		d.session = func() paxi.Client { return c.NewSession() }
 	default:
-		d.Client = paxi.NewHTTPClient(paxi.ID(*id))
+		c := paxi.NewHTTPClient(paxi.ID(*id))
+		d.Client = c
+		d.session = func() paxi.Client { return c.NewSession() }
 	}
 
 	b := paxi.NewBenchmark(d)
+	b.Admin = paxi.NewHTTPClient(paxi.ID(*id))
 	if *load {
 		b.Load()
 	} else {
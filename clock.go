@@ -0,0 +1,26 @@
+package paxi
+
+import "time"
+
+// Clock tells time and schedules functions of node,
+// simulation replaces wall clock with virtual clock of simulator
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a scheduled function of clock
+type Timer interface {
+	// Stop prevents timer from firing, returns false if timer already fired or stopped
+	Stop() bool
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -24,7 +24,15 @@ func usage() string {
 	s += "\t put key value\n"
 	s += "\t consensus key\n"
 	s += "\t crash id time\n"
+	s += "\t drop from to time\n"
+	s += "\t slow from to delay(ms) time\n"
+	s += "\t flaky from to p time\n"
+	s += "\t duplicate from to p time\n"
+	s += "\t reorder from to p time\n"
 	s += "\t partition time ids...\n"
+	s += "\t oneway time from,... to,...\n"
+	s += "\t faults id\n"
+	s += "\t heal [ids...]\n"
 	s += "\t exit\n"
 	return s
 }
@@ -39,8 +47,7 @@ func run(cmd string, args []string) {
 			fmt.Println("get KEY")
 			return
 		}
-		k, _ := strconv.Atoi(args[0])
-		v, _ := client.Get(paxi.Key(k))
+		v, _ := client.Get(paxi.Key(args[0]))
 		fmt.Println(string(v))
 
 	case "put":
@@ -48,8 +55,7 @@ func run(cmd string, args []string) {
 			fmt.Println("put KEY VALUE")
 			return
 		}
-		k, _ := strconv.Atoi(args[0])
-		client.Put(paxi.Key(k), []byte(args[1]))
+		client.Put(paxi.Key(args[0]), []byte(args[1]))
 		//fmt.Println(string(v))
 
 	case "consensus":
@@ -57,8 +63,7 @@ func run(cmd string, args []string) {
 			fmt.Println("consensus KEY")
 			return
 		}
-		k, _ := strconv.Atoi(args[0])
-		v := admin.Consensus(paxi.Key(k))
+		v := admin.Consensus(paxi.Key(args[0]))
 		fmt.Println(v)
 
 	case "crash":
@@ -74,6 +79,85 @@ func run(cmd string, args []string) {
 		}
 		admin.Crash(id, time)
 
+	case "drop":
+		if len(args) < 3 {
+			fmt.Println("drop from to time(s)")
+			return
+		}
+		time, err := strconv.Atoi(args[2])
+		if err != nil {
+			fmt.Println("time argument should be integer")
+			return
+		}
+		admin.Drop(paxi.ID(args[0]), paxi.ID(args[1]), time)
+
+	case "slow":
+		if len(args) < 4 {
+			fmt.Println("slow from to delay(ms) time(s)")
+			return
+		}
+		delay, err1 := strconv.Atoi(args[2])
+		time, err2 := strconv.Atoi(args[3])
+		if err1 != nil || err2 != nil {
+			fmt.Println("delay and time arguments should be integer")
+			return
+		}
+		admin.Slow(paxi.ID(args[0]), paxi.ID(args[1]), delay, time)
+
+	case "flaky", "duplicate", "reorder":
+		if len(args) < 4 {
+			fmt.Printf("%s from to p time(s)\n", cmd)
+			return
+		}
+		p, err1 := strconv.ParseFloat(args[2], 64)
+		time, err2 := strconv.Atoi(args[3])
+		if err1 != nil || err2 != nil {
+			fmt.Println("p should be float and time should be integer")
+			return
+		}
+		from, to := paxi.ID(args[0]), paxi.ID(args[1])
+		switch cmd {
+		case "flaky":
+			admin.Flaky(from, to, p, time)
+		case "duplicate":
+			admin.Duplicate(from, to, p, time)
+		case "reorder":
+			admin.Reorder(from, to, p, time)
+		}
+
+	case "oneway":
+		if len(args) < 3 {
+			fmt.Println("oneway time from,... to,...")
+			return
+		}
+		time, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("time argument should be integer")
+			return
+		}
+		admin.PartitionOneWay(time, ids(args[1]), ids(args[2]))
+
+	case "faults":
+		if len(args) < 1 {
+			fmt.Println("faults id")
+			return
+		}
+		faults, err := admin.Faults(paxi.ID(args[0]))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, f := range faults {
+			fmt.Printf("%+v\n", f)
+		}
+
+	case "heal":
+		nodes := make([]paxi.ID, 0)
+		for _, s := range args {
+			nodes = append(nodes, paxi.ID(s))
+		}
+		admin.Heal(nodes...)
+
 	case "partition":
 		if len(args) < 2 {
 			fmt.Println("partition time ids...")
@@ -100,6 +184,15 @@ func run(cmd string, args []string) {
 	}
 }
 
+// ids parses comma separated node ids
+func ids(s string) []paxi.ID {
+	ids := make([]paxi.ID, 0)
+	for _, id := range strings.Split(s, ",") {
+		ids = append(ids, paxi.ID(id))
+	}
+	return ids
+}
+
 func main() {
 	paxi.Init()
 
@@ -1,6 +1,7 @@
 package paxi
 
 import (
+	"bufio"
 	"encoding/gob"
 	"encoding/json"
 	"io"
@@ -12,13 +13,18 @@ import (
 // combines json and gob encoder decoder interface
 type Codec interface {
 	Scheme() string
-	Encode(interface{})
-	Decode(interface{})
+	Encode(interface{}) error
+	Decode(interface{}) error
 }
 
-// NewCodec creates new codec object based on scheme, i.e. json and gob
+// NewCodec creates new codec object based on scheme, i.e. json, gob and binary
 func NewCodec(scheme string, rw io.ReadWriter) Codec {
 	switch scheme {
+	case "binary":
+		return &codecBinary{
+			w: rw,
+			r: bufio.NewReader(rw),
+		}
 	case "json":
 		return &codecJSON{
 			encoder: json.NewEncoder(rw),
@@ -42,18 +48,16 @@ func (j *codecJSON) Scheme() string {
 	return "json"
 }
 
-func (j *codecJSON) Encode(m interface{}) {
+func (j *codecJSON) Encode(m interface{}) error {
 	err := j.encoder.Encode(m)
 	if err != nil {
 		log.Error(err)
 	}
+	return err
 }
 
-func (j *codecJSON) Decode(m interface{}) {
-	err := j.decoder.Decode(m)
-	if err != nil {
-		log.Error(err)
-	}
+func (j *codecJSON) Decode(m interface{}) error {
+	return j.decoder.Decode(m)
 }
 
 type codecGOB struct {
@@ -65,16 +69,14 @@ func (g *codecGOB) Scheme() string {
 	return "gob"
 }
 
-func (g *codecGOB) Encode(m interface{}) {
+func (g *codecGOB) Encode(m interface{}) error {
 	err := g.encoder.Encode(m)
 	if err != nil {
 		log.Error(err)
 	}
+	return err
 }
 
-func (g *codecGOB) Decode(m interface{}) {
-	err := g.decoder.Decode(m)
-	if err != nil {
-		log.Error(err)
-	}
+func (g *codecGOB) Decode(m interface{}) error {
+	return g.decoder.Decode(m)
 }
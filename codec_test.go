@@ -42,6 +42,105 @@ func TestCodecGob(t *testing.T) {
 	}
 }
 
+func TestCodecReplyError(t *testing.T) {
+	c := NewCodec("gob", new(bytes.Buffer))
+	for _, err := range []error{ErrCrashed, ErrStopped, ErrRedirect} {
+		var send interface{} = Reply{Command: Command{Key: "k"}, Err: err}
+		var recv interface{}
+		if e := c.Encode(&send); e != nil {
+			t.Fatalf("cannot encode reply of %v: %v", err, e)
+		}
+		c.Decode(&recv)
+		if recv.(Reply).Err != err {
+			t.Errorf("expect reply error %v, got %v", err, recv.(Reply).Err)
+		}
+	}
+}
+
+// C is a message with binary encoding
+type C struct {
+	B   Ballot
+	Cmd Command
+}
+
+func (c C) MarshalBinary() ([]byte, error) {
+	w := new(BinaryWriter)
+	w.PutBallot(c.B)
+	w.PutCommand(c.Cmd)
+	return w.Data(), nil
+}
+
+func (c *C) UnmarshalBinary(data []byte) error {
+	r := NewBinaryReader(data)
+	c.B = r.Ballot()
+	c.Cmd = r.Command()
+	return r.Err()
+}
+
+func TestCodecBinary(t *testing.T) {
+	gob.Register(A{})
+	RegisterMessage(1, C{})
+	var send interface{}
+	var recv interface{}
+
+	buf := new(bytes.Buffer)
+	c := NewCodec("binary", buf)
+
+	cmd := Command{Key: "k", Value: Value{}, ClientID: "1.1", CommandID: 7, Op: OpCAS, TTL: 10, Timestamp: 42}
+	send = C{NewBallot(3, "1.2"), cmd}
+	if err := c.Encode(&send); err != nil {
+		t.Fatal(err)
+	}
+	// unregistered message falls back to gob
+	c.Encode(A{1, "a", true})
+
+	if err := c.Decode(&recv); err != nil {
+		t.Fatal(err)
+	}
+	m, ok := recv.(C)
+	if !ok || m.B != send.(C).B || !m.Cmd.Equal(cmd) || m.Cmd.Value == nil || m.Cmd.Expect != nil {
+		t.Errorf("expect send %v and recv %v to be euqal", send, recv)
+	}
+	var a A
+	if err := c.Decode(&a); err != nil || a != (A{1, "a", true}) {
+		t.Errorf("expect gob message, got %v %v", a, err)
+	}
+
+	// type descriptor is sent only with the first gob message of codec
+	full := new(bytes.Buffer)
+	NewCodec("binary", full).Encode(A{2, "b", false})
+	c.Encode(A{2, "b", false})
+	if buf.Len() >= full.Len() {
+		t.Errorf("expect gob frame without type descriptor, got %d bytes of %d", buf.Len(), full.Len())
+	}
+	if err := c.Decode(&a); err != nil || a != (A{2, "b", false}) {
+		t.Errorf("expect gob message, got %v %v", a, err)
+	}
+
+	// truncated payload
+	frame, _ := C{}.MarshalBinary()
+	if err := new(C).UnmarshalBinary(frame[:0]); err == nil {
+		t.Error("expect error decoding truncated message")
+	}
+}
+
+func BenchmarkCodecBinary(b *testing.B) {
+	RegisterMessage(1, C{})
+	var send interface{}
+	var recv interface{}
+
+	buf := new(bytes.Buffer)
+	c := NewCodec("binary", buf)
+
+	send = C{NewBallot(1, "1.1"), Command{Key: "k", Value: Value("v")}}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Encode(&send)
+		c.Decode(&recv)
+	}
+}
+
 func BenchmarkCodecGob(b *testing.B) {
 	gob.Register(A{})
 	var send interface{}
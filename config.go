@@ -21,13 +21,32 @@ type Config struct {
 	Thrifty        bool    `json:"thrifty"`          // only send messages to a quorum
 	BufferSize     int     `json:"buffer_size"`      // buffer size for maps
 	ChanBufferSize int     `json:"chan_buffer_size"` // buffer size for channels
+	QueuePolicy    string  `json:"queue_policy"`     // policy of full outbound queue of peer {drop, block}, block stalls the sender
+	RequestBuffer  int     `json:"request_buffer"`   // capacity of client request queue, requests beyond are rejected
+	MaxPending     int     `json:"max_pending"`      // client requests admitted and not yet replied, unlimited if 0
+	RetryAfter     int     `json:"retry_after"`      // seconds rejected clients are told to wait before retry
+	Batching       bool    `json:"batching"`         // coalesce messages queued to the same peer into batches, tcp and tls transports only
+	BatchSize      int     `json:"batch_size"`       // byte budget of one batch
+	BatchInterval  int     `json:"batch_interval"`   // longest time in microseconds one batch takes queued messages
 	MultiVersion   bool    `json:"multiversion"`     // create multi-version database
+	WAL            string  `json:"wal"`              // directory of write-ahead log for durable protocol state, disabled if empty
+	Snapshot       int     `json:"snapshot"`         // number of executed slots between state snapshots, disabled if 0
+	Heartbeat      int     `json:"heartbeat"`        // leader heartbeat interval in ms, failure detection disabled if 0
+	Election       int     `json:"election"`         // ms without heartbeat before leader is suspected, randomized up to twice
+	SessionTTL     int     `json:"session_ttl"`      // seconds of command time before idle client session expires, no deduplication if 0
+	SessionWindow  int     `json:"session_window"`   // latest commands of client session kept with their results
+	StateMachine   string  `json:"state_machine"`    // replicated state machine {kv, counter, queue, lock}
+	Storage        string  `json:"storage"`          // storage engine of database {memory, file}
+	StorageDir     string  `json:"storage_dir"`      // directory of file storage
+	Fsync          string  `json:"fsync"`            // fsync policy of file storage {always, second, never}
+	Codec          string  `json:"codec"`            // codec for message serialization between nodes {gob, binary}
+	TLSCA          string  `json:"tls_ca"`           // CA certificate file that signs all node and client certificates
+	TLSCert        string  `json:"tls_cert"`         // certificate file of node or client, "%s" is replaced by its id
+	TLSKey         string  `json:"tls_key"`          // private key file of node or client, "%s" is replaced by its id
 	Benchmark      Bconfig `json:"benchmark"`        // benchmark configuration
 
 	// for future implementation
-	// Batching bool `json:"batching"`
 	// Consistency string `json:"consistency"`
-	// Codec string `json:"codec"` // codec for message serialization between nodes
 
 	n   int         // total number of nodes
 	z   int         // total number of zones
@@ -59,7 +78,19 @@ func MakeDefaultConfig() Config {
 		Threshold:      3,
 		BufferSize:     1024,
 		ChanBufferSize: 1024,
+		QueuePolicy:    QueueDrop,
+		RequestBuffer:  1024,
+		RetryAfter:     1,
+		BatchSize:      64 << 10,
+		BatchInterval:  500,
 		MultiVersion:   false,
+		Election:       1000,
+		SessionTTL:     600,
+		SessionWindow:  128,
+		StateMachine:   "kv",
+		Storage:        "memory",
+		Fsync:          FsyncSecond,
+		Codec:          "gob",
 		Benchmark:      DefaultBConfig(),
 	}
 }
@@ -105,6 +136,7 @@ func (c *Config) Load() {
 		log.Fatal(err)
 	}
 
+	c.n = 0
 	c.npz = make(map[int]int)
 	for id := range c.Addrs {
 		c.n++
@@ -0,0 +1,121 @@
+package paxi
+
+import (
+	"time"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// ErrCrashed is returned to client of crashed node
+var ErrCrashed = Error("node crashed")
+
+// Recover is delivered to the registered protocol handler when node recovers from crash,
+// before any other message, protocol rebuilds its state from what it persisted or starts empty
+type Recover struct{}
+
+// Durable is implemented by state machine that keeps its state across crash and restart,
+// it records log position of executed commands so that protocol replays only the log entries after it
+type Durable interface {
+	Applied() int           // log position after the last executed command or restored snapshot
+	SetApplied(applied int) // log position recorded atomically with the next executed command or restored snapshot
+}
+
+// Durable returns the state machine of node if it is durable, nil otherwise
+func (n *node) Durable() Durable {
+	if d, ok := n.StateMachine.(Durable); ok {
+		return d
+	}
+	return nil
+}
+
+func (n *node) crashed() bool {
+	n.RLock()
+	defer n.RUnlock()
+	return n.down
+}
+
+// Crash stops receiving and handling messages, timers and serving http requests as a crashed process,
+// only fault injection and heal requests are served to keep node healable,
+// node recovers after t seconds, or stays crashed until healed if t <= 0
+func (n *node) Crash(t int) {
+	n.Lock()
+	if !n.down {
+		n.down = true
+		close(n.stop)
+	}
+	n.life++
+	life := n.life
+	handling := n.handling
+	n.Unlock()
+	log.Infof("node %v crashed", n.id)
+
+	n.timers.stop()
+	n.Socket.Crash(t)
+	// message being handled finishes before recovery starts handling again
+	if handling != nil {
+		<-handling
+	}
+	if t > 0 {
+		n.clock.AfterFunc(time.Duration(t)*time.Second, func() {
+			n.recover(life)
+		})
+	}
+}
+
+// Heal removes all faults and recovers crashed node
+func (n *node) Heal() {
+	n.Socket.Heal()
+	n.RLock()
+	life := n.life
+	n.RUnlock()
+	n.recover(life)
+}
+
+// HealFault removes one fault, healing crash fault recovers crashed node
+func (n *node) HealFault(f Fault) {
+	n.Socket.HealFault(f)
+	if f.Type != FaultCrash {
+		return
+	}
+	n.RLock()
+	life := n.life
+	n.RUnlock()
+	n.recover(life)
+}
+
+// recover restarts node crashed by crash of life with empty volatile state,
+// state machine is reset only if protocol rebuilds its state on Recover
+func (n *node) recover(life int) {
+	n.Lock()
+	if !n.down || n.life != life || n.stopped {
+		n.Unlock()
+		return
+	}
+	n.down = false
+	n.stop = make(chan struct{})
+	n.forwards = make(map[string]*Request)
+	stop := n.stop
+	n.Unlock()
+
+	// messages queued before crash are lost
+	for len(n.MessageChan) > 0 {
+		<-n.MessageChan
+	}
+	n.fail(ErrCrashed)
+	if _, exists := n.handles["paxi.Recover"]; exists {
+		if n.Durable() == nil {
+			if err := n.Restore(nil); err != nil {
+				log.Errorf("node %v cannot reset state machine: %v", n.id, err)
+			}
+		}
+		n.call(Recover{})
+	}
+	log.Infof("node %v recovered", n.id)
+
+	if n.clock == simulator {
+		return
+	}
+	if len(n.handles) > 0 {
+		n.startHandle(stop)
+	}
+}
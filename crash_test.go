@@ -0,0 +1,127 @@
+package paxi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCrashRecover(t *testing.T) {
+	sim, n, received, done := faultyNodes(1)
+	defer done()
+	peer := sim.nodes["1.2"]
+	recovered := 0
+	peer.Register(Recover{}, func(Recover) { recovered++ })
+	peer.Execute(Command{Key: "k", Value: Value("v")})
+
+	peer.Crash(2)
+	n.Send("1.2", ping{N: 1})
+	replied := false
+	sim.Submit("1.2", Command{Key: "k"}, func(r Reply) { replied = r.Err == ErrCrashed })
+	sim.Run(time.Second)
+	if len(*received) != 0 || !replied {
+		t.Errorf("expected crashed node drops messages and requests, got %v", *received)
+	}
+
+	sim.Run(3 * time.Second)
+	if recovered != 1 || peer.crashed() {
+		t.Fatalf("expected node recovered once, got %d", recovered)
+	}
+	if v := peer.StateMachine.(Database).Get("k"); v != nil {
+		t.Errorf("expected empty volatile state after recovery, got %s", v)
+	}
+	n.Send("1.2", ping{N: 2})
+	sim.Run(4 * time.Second)
+	if len(*received) != 1 || (*received)[0].N != 2 {
+		t.Errorf("expected message received after recovery, got %v", *received)
+	}
+}
+
+func TestCrashStop(t *testing.T) {
+	sim, n, received, done := faultyNodes(1)
+	defer done()
+	peer := sim.nodes["1.2"]
+
+	peer.Crash(0)
+	sim.Run(time.Minute)
+	n.Send("1.2", ping{N: 1})
+	sim.Run(2 * time.Minute)
+	if len(*received) != 0 || !peer.crashed() {
+		t.Fatalf("expected node stays crashed, got %v", *received)
+	}
+
+	peer.Heal()
+	n.Send("1.2", ping{N: 2})
+	sim.Run(3 * time.Minute)
+	if len(*received) != 1 || peer.crashed() {
+		t.Errorf("expected healed node receives message, got %v", *received)
+	}
+}
+
+func TestCrashWithoutRecover(t *testing.T) {
+	sim, _, _, done := faultyNodes(1)
+	defer done()
+	peer := sim.nodes["1.2"]
+	peer.Execute(Command{Key: "k", Value: Value("v")})
+
+	peer.Crash(1)
+	sim.Run(2 * time.Second)
+	if peer.crashed() {
+		t.Fatal("expected node recovered")
+	}
+	if v := peer.StateMachine.(Database).Get("k"); string(v) != "v" {
+		t.Errorf("expected state kept by protocol without Recover handler, got %s", v)
+	}
+}
+
+func TestCrashHTTP(t *testing.T) {
+	_, n, _, done := faultyNodes(1)
+	defer done()
+	h := n.handler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/crash?t=0", nil))
+	if w.Code != http.StatusOK || !n.crashed() {
+		t.Fatalf("expected crashed node, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, KeyPath+"k", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected crashed node rejects request, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/faults", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected crashed node lists faults, got %d", w.Code)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/heal", nil))
+	if n.crashed() {
+		t.Error("expected node healed over http")
+	}
+
+	n.Crash(0)
+	n.Slow("1.2", 100, 0)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/heal?type=crash", nil))
+	if f := n.Faults(); n.crashed() || len(f) != 1 || f[0].Type != FaultSlow {
+		t.Errorf("expected only crash fault healed, got %+v", f)
+	}
+}
+
+func TestCrashLateReply(t *testing.T) {
+	_, n, _, done := faultyNodes(1)
+	defer done()
+	cmd := Command{Key: "k", ClientID: "1.1", CommandID: 1}
+	r := Request{Command: cmd, c: make(chan Reply, 1)}
+	n.Forward("1.2", r)
+	n.reply(Reply{Command: cmd})
+	if len(n.forwards) != 0 || len(r.c) != 1 {
+		t.Errorf("expected reply passed to client and forward removed, got %d forwards", len(n.forwards))
+	}
+
+	// reply of request forgotten by crash is dropped
+	n.Forward("1.2", r)
+	n.Crash(1)
+	n.Heal()
+	n.reply(Reply{Command: cmd})
+}
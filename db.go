@@ -2,72 +2,189 @@ package paxi
 
 import (
 	"bytes"
+	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
+
+	"github.com/ailidani/paxi/log"
 )
 
 // Key type of the key-value database
-// TODO key should be general too
-type Key int
+type Key string
 
 // Value type of key-value database
 type Value []byte
 
+// Operation type of command
+type Operation uint8
+
+// operations of key-value database
+const (
+	OpGet       Operation = iota // reads value of key, same as OpPut if command value is not nil
+	OpPut                        // writes value to key and returns previous value
+	OpDelete                     // deletes key and returns previous value
+	OpCAS                        // writes value if current value equals expected one, deletes key if value is nil
+	OpIncrement                  // adds command value as integer (default 1) to the integer value of key and returns new value
+	OpAppend                     // appends command value to value of key and returns new value
+	OpScan                       // reads ordered key-value pairs in range starting from key
+	OpTxn                        // executes list of commands in command value atomically
+)
+
+var operations = [...]string{"Get", "Put", "Delete", "CAS", "Increment", "Append", "Scan", "Txn"}
+
+func (o Operation) String() string {
+	if int(o) < len(operations) {
+		return operations[o]
+	}
+	return fmt.Sprintf("Operation(%d)", o)
+}
+
+// errors of command execution
+var (
+	ErrCASFailed    = Error("compare and swap failed")
+	ErrNotInteger   = Error("value is not an integer")
+	ErrNotSupported = Error("operation not supported")
+)
+
+// Error is a serializable error that can be replied to remote nodes
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
 // Command of key-value database
 type Command struct {
 	Key       Key
 	Value     Value
 	ClientID  ID
 	CommandID int
+	Session   int64 // session of client the command id belongs to
+	Op        Operation
+	Expect    Value         // expected current value for compare and swap, empty if key should not exist
+	TTL       time.Duration // time to live of written key, never expires if 0
+	Timestamp int64         // logical time of command in unix nanoseconds, assigned by the node receiving it
 }
 
 func (c Command) Empty() bool {
-	if c.Key == 0 && c.Value == nil && c.ClientID == "" && c.CommandID == 0 {
+	if c.Key == "" && c.Value == nil && c.ClientID == "" && c.CommandID == 0 && c.Op == OpGet {
 		return true
 	}
 	return false
 }
 
+// scanRange is the upper bound and limit of scan command encoded as command value
+type scanRange struct {
+	To    Key `json:"to"`
+	Limit int `json:"limit"`
+}
+
+// KeyValue is one key-value pair in the result of scan command
+type KeyValue struct {
+	Key   Key   `json:"key"`
+	Value Value `json:"value"`
+}
+
+// NewScan creates a command that reads keys in range [from, to) in order with at most limit results
+// range is unbounded above if to is empty, and results are unlimited if limit is not positive
+// the result value is JSON encoded list of KeyValue
+func NewScan(from, to Key, limit int) Command {
+	v, _ := json.Marshal(scanRange{to, limit})
+	return Command{Key: from, Value: v, Op: OpScan}
+}
+
+// Range returns the key range and limit of scan command
+func (c Command) Range() (from, to Key, limit int) {
+	var r scanRange
+	if len(c.Value) > 0 {
+		if err := json.Unmarshal(c.Value, &r); err != nil {
+			log.Errorf("invalid scan range %s", c.Value)
+		}
+	}
+	return c.Key, r.To, r.Limit
+}
+
+// Operation returns the operation type of command
+func (c Command) Operation() Operation {
+	if c.Op == OpGet && c.Value != nil {
+		return OpPut
+	}
+	return c.Op
+}
+
 func (c Command) IsRead() bool {
-	return c.Value == nil
+	return c.Operation() == OpGet || c.Operation() == OpScan
 }
 
 func (c Command) IsWrite() bool {
-	return c.Value != nil
+	return !c.IsRead()
 }
 
 func (c Command) Equal(a Command) bool {
-	return c.Key == a.Key && bytes.Equal(c.Value, a.Value) && c.ClientID == a.ClientID && c.CommandID == a.CommandID
+	return c.Key == a.Key && bytes.Equal(c.Value, a.Value) && c.ClientID == a.ClientID && c.CommandID == a.CommandID &&
+		c.Session == a.Session && c.Operation() == a.Operation() && bytes.Equal(c.Expect, a.Expect) && c.TTL == a.TTL && c.Timestamp == a.Timestamp
 }
 
 func (c Command) String() string {
-	if c.Value == nil {
-		return fmt.Sprintf("Get{key=%v id=%s cid=%d}", c.Key, c.ClientID, c.CommandID)
+	switch c.Operation() {
+	case OpGet, OpDelete:
+		return fmt.Sprintf("%v{key=%v id=%s cid=%d}", c.Operation(), c.Key, c.ClientID, c.CommandID)
+	case OpCAS:
+		return fmt.Sprintf("CAS{key=%v expect=%x value=%x id=%s cid=%d}", c.Key, c.Expect, c.Value, c.ClientID, c.CommandID)
+	case OpScan:
+		from, to, limit := c.Range()
+		return fmt.Sprintf("Scan{from=%v to=%v limit=%d id=%s cid=%d}", from, to, limit, c.ClientID, c.CommandID)
+	case OpTxn:
+		return fmt.Sprintf("Txn{cmds=%v id=%s cid=%d}", c.Commands(), c.ClientID, c.CommandID)
 	}
-	return fmt.Sprintf("Put{key=%v value=%x id=%s cid=%d", c.Key, c.Value, c.ClientID, c.CommandID)
+	return fmt.Sprintf("%v{key=%v value=%x id=%s cid=%d}", c.Operation(), c.Key, c.Value, c.ClientID, c.CommandID)
 }
 
-// Database defines a database interface
-// TODO replace with more general StateMachine interface
+// Database defines a key-value database interface as one of the state machines
 type Database interface {
-	Execute(Command) Value
+	StateMachine
 	History(Key) []Value
 	Get(Key) Value
 	Put(Key, Value)
+	Scan(from, to Key, limit int) []KeyValue
+	TTL(Key) time.Duration
 }
 
 // Database implements a multi-version key-value datastore as the StateMachine
 type database struct {
 	sync.RWMutex
 	data         map[Key]Value
+	sorted       sortedKeys
 	version      int
 	multiversion bool
 	history      map[Key][]Value
+	expires      expiry
+}
+
+// NewDatabase returns database of the storage engine in configuration for node id
+func NewDatabase(id ID) Database {
+	switch config.Storage {
+	case "", "memory":
+		return NewMemoryDatabase()
+	case "file":
+		db, err := NewFileDatabase(filepath.Join(config.StorageDir, string(id)+".db"), config.Fsync)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return db
+	default:
+		log.Fatalf("unknown storage engine %s", config.Storage)
+	}
+	return nil
 }
 
-// NewDatabase returns database that impelements Database interface
-func NewDatabase() Database {
+// NewMemoryDatabase returns in-memory database that impelements Database interface
+func NewMemoryDatabase() Database {
 	return &database{
 		data:         make(map[Key]Value),
 		version:      0,
@@ -99,29 +216,150 @@ func (d *database) Execute(c interface{}) interface{} {
 }
 */
 
-// Execute executes a command agaist database
-func (d *database) Execute(c Command) Value {
-	d.Lock()
-	defer d.Unlock()
+// kv is the primitive operations of database storage engines
+type kv interface {
+	get(Key) Value
+	put(Key, Value)
+	delete(Key)
+	keys(from, to Key, limit int) []Key
+	expiry() *expiry
+	expire(Key, int64) // sets deadline of key in logical time, 0 clears it
+}
+
+// execute executes command against storage engine s, caller must hold the lock of s
+// write command advances the logical clock and removes expired keys before execution,
+// read command does not change state and treats keys expired at its timestamp as absent
+func execute(s kv, c Command) (Value, error) {
+	e := s.expiry()
+	now := e.now(c.Timestamp)
+	if c.IsWrite() {
+		for _, k := range e.advance(c.Timestamp) {
+			s.delete(k)
+		}
+	}
+
+	var v Value
+	if c.Operation() != OpScan && !e.expired(c.Key, now) {
+		v = s.get(c.Key)
+	}
+	switch c.Operation() {
+	case OpGet:
+		return v, nil
+
+	case OpPut:
+		s.put(c.Key, c.Value)
+		refresh(s, c, true)
+		return v, nil
 
-	// get previous value
-	v := d.data[c.Key]
+	case OpDelete:
+		if v != nil {
+			s.delete(c.Key)
+		}
+		return v, nil
+
+	case OpCAS:
+		if !bytes.Equal(v, c.Expect) {
+			return v, ErrCASFailed
+		}
+		if c.Value == nil {
+			s.delete(c.Key)
+		} else {
+			s.put(c.Key, c.Value)
+			refresh(s, c, true)
+		}
+		return v, nil
 
-	// writes new value
-	d.put(c.Key, c.Value)
+	case OpIncrement:
+		var x, delta int64 = 0, 1
+		var err error
+		if len(v) > 0 {
+			if x, err = strconv.ParseInt(string(v), 10, 64); err != nil {
+				return v, ErrNotInteger
+			}
+		}
+		if len(c.Value) > 0 {
+			if delta, err = strconv.ParseInt(string(c.Value), 10, 64); err != nil {
+				return v, ErrNotInteger
+			}
+		}
+		n := Value(strconv.FormatInt(x+delta, 10))
+		s.put(c.Key, n)
+		refresh(s, c, false)
+		return n, nil
 
-	return v
+	case OpAppend:
+		n := make(Value, 0, len(v)+len(c.Value))
+		n = append(append(n, v...), c.Value...)
+		s.put(c.Key, n)
+		refresh(s, c, false)
+		return n, nil
+
+	case OpScan:
+		from, to, limit := c.Range()
+		kvs := make([]KeyValue, 0)
+		if len(e.deadlines) == 0 {
+			for _, k := range s.keys(from, to, limit) {
+				kvs = append(kvs, KeyValue{k, s.get(k)})
+			}
+			return json.Marshal(kvs)
+		}
+		for _, k := range s.keys(from, to, 0) {
+			if limit > 0 && len(kvs) >= limit {
+				break
+			}
+			if !e.expired(k, now) {
+				kvs = append(kvs, KeyValue{k, s.get(k)})
+			}
+		}
+		return json.Marshal(kvs)
+
+	case OpTxn:
+		return executeTxn(s, c)
+	}
+	return nil, ErrNotSupported
+}
+
+// Execute executes a command agaist database
+func (d *database) Execute(c Command) (Value, error) {
+	d.Lock()
+	defer d.Unlock()
+	return execute(d, c)
 }
 
 // Get gets the current value and version of given key
 func (d *database) Get(k Key) Value {
 	d.RLock()
 	defer d.RUnlock()
+	if d.expires.expired(k, d.expires.clock) {
+		return nil
+	}
+	return d.get(k)
+}
+
+// TTL returns remaining time to live of given key in logical time, 0 if key never expires
+func (d *database) TTL(k Key) time.Duration {
+	d.RLock()
+	defer d.RUnlock()
+	return d.expires.ttl(k)
+}
+
+func (d *database) expiry() *expiry {
+	return &d.expires
+}
+
+func (d *database) expire(k Key, deadline int64) {
+	d.expires.set(k, deadline)
+}
+
+func (d *database) get(k Key) Value {
 	return d.data[k]
 }
 
 func (d *database) put(k Key, v Value) {
 	if v != nil {
+		if _, exists := d.data[k]; !exists {
+			d.sorted.insert(k)
+		}
 		d.data[k] = v
 		d.version++
 		if d.multiversion {
@@ -133,6 +371,17 @@ func (d *database) put(k Key, v Value) {
 	}
 }
 
+// delete removes the key, deletion is recorded as nil value in history
+func (d *database) delete(k Key) {
+	d.expires.set(k, 0)
+	delete(d.data, k)
+	d.sorted.remove(k)
+	d.version++
+	if d.multiversion {
+		d.history[k] = append(d.history[k], nil)
+	}
+}
+
 // Put puts a new value of given key
 func (d *database) Put(k Key, v Value) {
 	d.Lock()
@@ -140,6 +389,26 @@ func (d *database) Put(k Key, v Value) {
 	d.put(k, v)
 }
 
+func (d *database) keys(from, to Key, limit int) []Key {
+	return d.sorted.scan(from, to, limit)
+}
+
+// Scan returns key-value pairs in range [from, to) in order with at most limit results
+func (d *database) Scan(from, to Key, limit int) []KeyValue {
+	d.RLock()
+	defer d.RUnlock()
+	kvs := make([]KeyValue, 0)
+	for _, k := range d.keys(from, to, 0) {
+		if limit > 0 && len(kvs) >= limit {
+			break
+		}
+		if !d.expires.expired(k, d.expires.clock) {
+			kvs = append(kvs, KeyValue{k, d.data[k]})
+		}
+	}
+	return kvs
+}
+
 // Version returns current version of given key
 func (d *database) Version(k Key) int {
 	d.RLock()
@@ -154,6 +423,88 @@ func (d *database) History(k Key) []Value {
 	return d.history[k]
 }
 
+// dbState is the serializable state of database used by snapshots
+type dbState struct {
+	Data      map[Key]Value
+	Version   int
+	History   map[Key][]Value
+	Clock     int64
+	Deadlines map[Key]int64
+	Deleted   map[Key][]int // positions of deletions in history of key
+}
+
+// deleted returns true if i-th value in history of key is a deletion
+func (s dbState) deleted(k Key, i int) bool {
+	for _, j := range s.Deleted[k] {
+		if j == i {
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot returns the serialized state of database
+func (d *database) Snapshot() []byte {
+	d.RLock()
+	defer d.RUnlock()
+	state := dbState{d.data, d.version, d.history, d.expires.clock, d.expires.deadlines, make(map[Key][]int)}
+	for k, values := range d.history {
+		for i, v := range values {
+			if v == nil {
+				state.Deleted[k] = append(state.Deleted[k], i)
+			}
+		}
+	}
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(state)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// Restore replaces the state of database with snapshot, empty snapshot resets the database
+func (d *database) Restore(snapshot []byte) error {
+	state := dbState{
+		Data:    make(map[Key]Value),
+		History: make(map[Key][]Value),
+	}
+	if len(snapshot) > 0 {
+		err := gob.NewDecoder(bytes.NewReader(snapshot)).Decode(&state)
+		if err != nil {
+			return err
+		}
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.data = state.Data
+	d.version = state.Version
+	d.history = state.History
+	d.expires = expiry{clock: state.Clock, deadlines: state.Deadlines}
+	if d.data == nil {
+		d.data = make(map[Key]Value)
+	}
+	if d.history == nil {
+		d.history = make(map[Key][]Value)
+	}
+	for k, values := range d.history {
+		for i := range values {
+			if state.deleted(k, i) {
+				values[i] = nil
+			} else if values[i] == nil {
+				values[i] = Value{}
+			}
+		}
+	}
+	d.sorted = make(sortedKeys, 0, len(d.data))
+	for k := range d.data {
+		d.sorted = append(d.sorted, k)
+	}
+	sort.Slice(d.sorted, func(i, j int) bool { return d.sorted[i] < d.sorted[j] })
+	return nil
+}
+
 func (d *database) String() string {
 	d.RLock()
 	defer d.RUnlock()
@@ -161,14 +512,32 @@ func (d *database) String() string {
 	return string(b)
 }
 
-// Conflict checks if two commands are conflicting as reorder them will end in different states
+// Conflict checks if two commands are conflicting as reorder them will end in different states or results
+// every operation other than read mutates the key or depends on its current value, so only two reads commute
+// scan conflicts with any write to a key in its range
 func Conflict(gamma *Command, delta *Command) bool {
-	if gamma.Key == delta.Key {
-		if !gamma.IsRead() || !delta.IsRead() {
-			return true
-		}
+	if gamma.Operation() == OpTxn {
+		return ConflictBatch(gamma.Commands(), []Command{*delta})
 	}
-	return false
+	if delta.Operation() == OpTxn {
+		return ConflictBatch([]Command{*gamma}, delta.Commands())
+	}
+	if gamma.IsRead() && delta.IsRead() {
+		return false
+	}
+	if gamma.Operation() == OpScan {
+		return gamma.covers(delta.Key)
+	}
+	if delta.Operation() == OpScan {
+		return delta.covers(gamma.Key)
+	}
+	return gamma.Key == delta.Key
+}
+
+// covers checks if key is in the range of scan command
+func (c Command) covers(k Key) bool {
+	from, to, _ := c.Range()
+	return k >= from && (to == "" || k < to)
 }
 
 // ConflictBatch checks if two batchs of commands are conflict
@@ -0,0 +1,209 @@
+package paxi
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestDatabaseSnapshot(t *testing.T) {
+	db := NewMemoryDatabase()
+	db.Put("1", Value("a"))
+	db.Put("2", Value("b"))
+	snapshot := db.Snapshot()
+
+	db.Put("1", Value("c"))
+	if err := db.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(db.Get("1"), Value("a")) || !bytes.Equal(db.Get("2"), Value("b")) {
+		t.Errorf("restored database %v", db)
+	}
+
+	db.Restore(nil)
+	if db.Get("1") != nil {
+		t.Errorf("expected empty database after reset, got %v", db)
+	}
+}
+
+func TestDatabaseOperations(t *testing.T) {
+	db := NewMemoryDatabase()
+	db.Execute(Command{Key: "k", Value: Value("a")})
+
+	_, err := db.Execute(Command{Key: "k", Value: Value("b"), Op: OpCAS, Expect: Value("x")})
+	if err != ErrCASFailed {
+		t.Errorf("expected cas failure, got %v", err)
+	}
+	_, err = db.Execute(Command{Key: "k", Value: Value("b"), Op: OpCAS, Expect: Value("a")})
+	if err != nil || !bytes.Equal(db.Get("k"), Value("b")) {
+		t.Errorf("expected cas success, got %v %s", err, db.Get("k"))
+	}
+
+	v, _ := db.Execute(Command{Key: "k", Value: Value("c"), Op: OpAppend})
+	if !bytes.Equal(v, Value("bc")) {
+		t.Errorf("expected appended value bc, got %s", v)
+	}
+
+	v, _ = db.Execute(Command{Key: "k", Op: OpDelete})
+	if !bytes.Equal(v, Value("bc")) || db.Get("k") != nil {
+		t.Errorf("expected deleted key, got %s", db.Get("k"))
+	}
+
+	db.Execute(Command{Key: "n", Op: OpIncrement})
+	v, _ = db.Execute(Command{Key: "n", Value: Value("5"), Op: OpIncrement})
+	if !bytes.Equal(v, Value("6")) {
+		t.Errorf("expected counter 6, got %s", v)
+	}
+	if _, err = db.Execute(Command{Key: "n", Value: Value("x"), Op: OpIncrement}); err != ErrNotInteger {
+		t.Errorf("expected not integer error, got %v", err)
+	}
+}
+
+func TestConflict(t *testing.T) {
+	read := Command{Key: "k"}
+	if Conflict(&read, &read) {
+		t.Error("reads should not conflict")
+	}
+	del := Command{Key: "k", Op: OpDelete}
+	if !Conflict(&read, &del) {
+		t.Error("delete should conflict with read")
+	}
+}
+
+func TestDatabaseScan(t *testing.T) {
+	db := NewMemoryDatabase()
+	for _, k := range []Key{"b/2", "a/1", "b/1", "c", "b/3"} {
+		db.Put(k, Value(k))
+	}
+	db.Execute(Command{Key: "b/3", Op: OpDelete})
+
+	v, err := db.Execute(NewScan("b/", "b0", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kvs []KeyValue
+	if err := json.Unmarshal(v, &kvs); err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 2 || kvs[0].Key != "b/1" || kvs[1].Key != "b/2" || !bytes.Equal(kvs[1].Value, Value("b/2")) {
+		t.Errorf("unexpected scan result %v", kvs)
+	}
+
+	if kvs := db.Scan("", "", 3); len(kvs) != 3 || kvs[0].Key != "a/1" {
+		t.Errorf("unexpected limited scan result %v", kvs)
+	}
+
+	scan := NewScan("b/", "b0", 0)
+	put := Command{Key: "b/5", Value: Value("x")}
+	if !Conflict(&scan, &put) {
+		t.Error("scan should conflict with write in range")
+	}
+	put.Key = "c"
+	if Conflict(&scan, &put) {
+		t.Error("scan should not conflict with write out of range")
+	}
+}
+
+func TestDatabaseTxn(t *testing.T) {
+	db := NewMemoryDatabase()
+	db.Put("a", Value("10"))
+
+	txn := NewTxn([]Command{
+		{Key: "a", Value: Value("-3"), Op: OpIncrement},
+		{Key: "b", Value: Value("3"), Op: OpIncrement},
+		{Key: "b"},
+		NewScan("", "", 0),
+	})
+	v, err := db.Execute(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []Value
+	if err := json.Unmarshal(v, &values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 4 || !bytes.Equal(values[0], Value("7")) || !bytes.Equal(values[2], Value("3")) {
+		t.Errorf("unexpected transaction result %s", v)
+	}
+	var kvs []KeyValue
+	json.Unmarshal(values[3], &kvs)
+	if len(kvs) != 2 {
+		t.Errorf("scan in transaction should see its own writes, got %s", values[3])
+	}
+
+	// failed command aborts the whole transaction
+	txn = NewTxn([]Command{
+		{Key: "a", Op: OpDelete},
+		{Key: "b", Value: Value("4"), Op: OpCAS, Expect: Value("0")},
+	})
+	if _, err := db.Execute(txn); err != ErrCASFailed {
+		t.Errorf("expected cas failure, got %v", err)
+	}
+	if !bytes.Equal(db.Get("a"), Value("7")) || !bytes.Equal(db.Get("b"), Value("3")) {
+		t.Errorf("aborted transaction changed database a=%s b=%s", db.Get("a"), db.Get("b"))
+	}
+
+	if keys := txn.Keys(); len(keys) != 2 || keys[0] != "a" {
+		t.Errorf("unexpected transaction keys %v", keys)
+	}
+	get := Command{Key: "b"}
+	if !Conflict(&txn, &get) {
+		t.Error("transaction should conflict with read of its written key")
+	}
+}
+
+func TestTransactionRequest(t *testing.T) {
+	txn := Transaction{
+		Commands: []Command{{Key: "a", Value: Value("1")}, {Key: "b"}},
+		c:        make(chan TransactionReply, 1),
+	}
+	req := txn.Request()
+	values, _ := json.Marshal([]Value{nil, Value("1")})
+	req.Reply(Reply{Value: values})
+	select {
+	case r := <-txn.c:
+		if !r.OK || len(r.Commands) != 2 || len(r.Values) != 2 || string(r.Values[1]) != "1" {
+			t.Errorf("unexpected transaction reply %+v", r)
+		}
+	default:
+		t.Fatal("expected transaction reply sent by request reply")
+	}
+}
+
+func TestDatabaseTTL(t *testing.T) {
+	db := NewMemoryDatabase()
+	db.Execute(Command{Key: "session", Value: Value("a"), TTL: 10, Timestamp: 100})
+	db.Execute(Command{Key: "k", Value: Value("b"), Timestamp: 100})
+
+	if ttl := db.TTL("session"); ttl != 10 {
+		t.Errorf("expected ttl 10, got %v", ttl)
+	}
+	// read at later logical time sees expiry without changing state
+	if v, _ := db.Execute(Command{Key: "session", Timestamp: 110}); v != nil {
+		t.Errorf("expected expired key, got %s", v)
+	}
+	if v := db.Get("session"); !bytes.Equal(v, Value("a")) {
+		t.Errorf("read should not expire key, got %s", v)
+	}
+
+	// append keeps the deadline, put without ttl clears it
+	db.Execute(Command{Key: "session", Value: Value("b"), Op: OpAppend, Timestamp: 105})
+	if ttl := db.TTL("session"); ttl != 5 {
+		t.Errorf("expected ttl 5, got %v", ttl)
+	}
+	snapshot := db.Snapshot()
+
+	db.Execute(Command{Key: "k", Value: Value("c"), Timestamp: 120})
+	if db.Get("session") != nil || len(db.Scan("", "", 0)) != 1 {
+		t.Errorf("expected session expired by write at later logical time, got %v", db)
+	}
+
+	db.Restore(snapshot)
+	if !bytes.Equal(db.Get("session"), Value("ab")) || db.TTL("session") != 5 {
+		t.Errorf("expected restored deadline, got %s %v", db.Get("session"), db.TTL("session"))
+	}
+	db.Execute(Command{Key: "session", Value: Value("d"), Timestamp: 106})
+	if db.TTL("session") != 0 {
+		t.Errorf("put without ttl should clear deadline, got %v", db.TTL("session"))
+	}
+}
@@ -1,8 +1,6 @@
 package dynamo
 
 import (
-	"encoding/binary"
-
 	"github.com/ailidani/paxi"
 	"github.com/ailidani/paxi/lib"
 	"github.com/ailidani/paxi/log"
@@ -34,9 +32,7 @@ func NewReplica(id paxi.ID) *Replica {
 
 // DHT
 func (r *Replica) hash(key paxi.Key) paxi.ID {
-	b := make([]byte, 8)
-	binary.LittleEndian.PutUint64(b, uint64(key))
-	return r.ring.Get(b).(paxi.ID)
+	return r.ring.Get([]byte(key)).(paxi.ID)
 }
 
 // replicas returns the next 2 neighbors of current node as its replicas
@@ -68,10 +64,11 @@ func (r *Replica) HandleRequest(m paxi.Request) {
 		var replica paxi.ID
 		for _, replica = range replicas {
 			if r.ID() == replica {
-				v := r.Node.Execute(m.Command)
+				v, err := r.Node.Execute(m.Command)
 				m.Reply(paxi.Reply{
 					Command: m.Command,
 					Value:   v,
+					Err:     err,
 				})
 				return
 			}
@@ -79,7 +76,7 @@ func (r *Replica) HandleRequest(m paxi.Request) {
 		go r.Forward(replica, m)
 	} else {
 		if id == r.ID() {
-			v := r.Node.Execute(m.Command)
+			v, err := r.Node.Execute(m.Command)
 			for _, id := range r.replicas(r.ID()) {
 				r.Send(id, Replicate{
 					Command: m.Command,
@@ -88,6 +85,7 @@ func (r *Replica) HandleRequest(m paxi.Request) {
 			m.Reply(paxi.Reply{
 				Command: m.Command,
 				Value:   v,
+				Err:     err,
 			})
 		} else {
 			go r.Forward(id, m)
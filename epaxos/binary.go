@@ -0,0 +1,115 @@
+package epaxos
+
+import "github.com/ailidani/paxi"
+
+// MarshalBinary implements encoding.BinaryMarshaler for binary codec
+func (m PreAccept) MarshalBinary() ([]byte, error) {
+	w := new(paxi.BinaryWriter)
+	w.PutBallot(m.Ballot)
+	w.PutString(string(m.Replica))
+	w.PutInt(int64(m.Slot))
+	w.PutCommand(m.Command)
+	w.PutInt(int64(m.Seq))
+	w.PutIDInts(m.Dep)
+	return w.Data(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for binary codec
+func (m *PreAccept) UnmarshalBinary(data []byte) error {
+	r := paxi.NewBinaryReader(data)
+	m.Ballot = r.Ballot()
+	m.Replica = paxi.ID(r.String())
+	m.Slot = int(r.Int())
+	m.Command = r.Command()
+	m.Seq = int(r.Int())
+	m.Dep = r.IDInts()
+	return r.Err()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler for binary codec
+func (m PreAcceptReply) MarshalBinary() ([]byte, error) {
+	w := new(paxi.BinaryWriter)
+	w.PutBallot(m.Ballot)
+	w.PutString(string(m.Replica))
+	w.PutInt(int64(m.Slot))
+	w.PutInt(int64(m.Seq))
+	w.PutIDInts(m.Dep)
+	w.PutIDInts(m.Committed)
+	return w.Data(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for binary codec
+func (m *PreAcceptReply) UnmarshalBinary(data []byte) error {
+	r := paxi.NewBinaryReader(data)
+	m.Ballot = r.Ballot()
+	m.Replica = paxi.ID(r.String())
+	m.Slot = int(r.Int())
+	m.Seq = int(r.Int())
+	m.Dep = r.IDInts()
+	m.Committed = r.IDInts()
+	return r.Err()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler for binary codec
+func (m Accept) MarshalBinary() ([]byte, error) {
+	w := new(paxi.BinaryWriter)
+	w.PutBallot(m.Ballot)
+	w.PutString(string(m.Replica))
+	w.PutInt(int64(m.Slot))
+	w.PutInt(int64(m.Seq))
+	w.PutIDInts(m.Dep)
+	return w.Data(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for binary codec
+func (m *Accept) UnmarshalBinary(data []byte) error {
+	r := paxi.NewBinaryReader(data)
+	m.Ballot = r.Ballot()
+	m.Replica = paxi.ID(r.String())
+	m.Slot = int(r.Int())
+	m.Seq = int(r.Int())
+	m.Dep = r.IDInts()
+	return r.Err()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler for binary codec
+func (m AcceptReply) MarshalBinary() ([]byte, error) {
+	w := new(paxi.BinaryWriter)
+	w.PutBallot(m.Ballot)
+	w.PutString(string(m.Replica))
+	w.PutInt(int64(m.Slot))
+	return w.Data(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for binary codec
+func (m *AcceptReply) UnmarshalBinary(data []byte) error {
+	r := paxi.NewBinaryReader(data)
+	m.Ballot = r.Ballot()
+	m.Replica = paxi.ID(r.String())
+	m.Slot = int(r.Int())
+	return r.Err()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler for binary codec
+func (m Commit) MarshalBinary() ([]byte, error) {
+	w := new(paxi.BinaryWriter)
+	w.PutBallot(m.Ballot)
+	w.PutString(string(m.Replica))
+	w.PutInt(int64(m.Slot))
+	w.PutCommand(m.Command)
+	w.PutInt(int64(m.Seq))
+	w.PutIDInts(m.Dep)
+	return w.Data(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for binary codec
+func (m *Commit) UnmarshalBinary(data []byte) error {
+	r := paxi.NewBinaryReader(data)
+	m.Ballot = r.Ballot()
+	m.Replica = paxi.ID(r.String())
+	m.Slot = int(r.Int())
+	m.Command = r.Command()
+	m.Seq = int(r.Int())
+	m.Dep = r.IDInts()
+	return r.Err()
+}
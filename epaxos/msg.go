@@ -13,6 +13,13 @@ func init() {
 	gob.Register(Accept{})
 	gob.Register(AcceptReply{})
 	gob.Register(Commit{})
+
+	// hot messages with hand-written binary encoding
+	paxi.RegisterMessage(20, PreAccept{})
+	paxi.RegisterMessage(21, PreAcceptReply{})
+	paxi.RegisterMessage(22, Accept{})
+	paxi.RegisterMessage(23, AcceptReply{})
+	paxi.RegisterMessage(24, Commit{})
 }
 
 type PreAccept struct {
@@ -369,11 +369,12 @@ func (r *Replica) execute() {
 			if i.status != COMMITTED {
 				break
 			}
-			v := r.Execute(i.cmd)
+			v, err := r.Execute(i.cmd)
 			if i.request != nil {
 				i.request.Reply(paxi.Reply{
 					Command: i.cmd,
 					Value:   v,
+					Err:     err,
 				})
 			}
 			if s == r.executed[id]+1 {
@@ -399,11 +400,12 @@ func (r *Replica) execute2() {
 			if i.status != COMMITTED {
 				continue
 			}
-			v := r.Execute(i.cmd)
+			v, err := r.Execute(i.cmd)
 			if i.request != nil {
 				i.request.Reply(paxi.Reply{
 					Command: i.cmd,
 					Value:   v,
+					Err:     err,
 				})
 				i.request = nil
 			}
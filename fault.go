@@ -0,0 +1,145 @@
+package paxi
+
+import (
+	"sort"
+	"time"
+)
+
+// types of fault injected into socket
+const (
+	FaultCrash     = "crash"     // node neither sends nor receives messages
+	FaultDrop      = "drop"      // messages to peer are dropped
+	FaultSlow      = "slow"      // messages to peer are delayed
+	FaultFlaky     = "flaky"     // messages to peer are dropped by chance
+	FaultDuplicate = "duplicate" // messages to peer are sent twice by chance
+	FaultReorder   = "reorder"   // messages to peer are held back by chance
+	FaultPartition = "partition" // messages to every peer of partition are dropped
+)
+
+// reorderDelay is the maximum time a reordered message is held back
+const reorderDelay = 100 * time.Millisecond
+
+// Fault is an active fault of socket
+type Fault struct {
+	Type  string    `json:"type"`
+	To    ID        `json:"to,omitempty"`    // peer of faulty messages, empty for crash and partition
+	ID    int       `json:"id,omitempty"`    // id of partition, every injected partition is healed apart
+	Peers []ID      `json:"peers,omitempty"` // peers cut off by partition
+	Delay int       `json:"delay,omitempty"` // delay of slow messages in ms
+	P     float64   `json:"p,omitempty"`     // chance of flaky, duplicate and reorder
+	Until time.Time `json:"until,omitempty"` // time fault heals, zero if until healed
+	seq   int
+}
+
+type faultKey struct {
+	Type string
+	To   ID
+	ID   int
+}
+
+func (f Fault) key() faultKey {
+	return faultKey{f.Type, f.To, f.ID}
+}
+
+// inject adds fault that heals after t seconds, t <= 0 lasts until healed
+func (s *socket) inject(f Fault, t int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seq++
+	f.seq = s.seq
+	if f.Type == FaultPartition {
+		f.ID = f.seq
+	}
+	key := f.key()
+	if t > 0 {
+		d := time.Duration(t) * time.Second
+		f.Until = s.clock.Now().Add(d)
+		s.clock.AfterFunc(d, func() {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			// fault is not replaced by later injection
+			if s.faults[key].seq == f.seq {
+				delete(s.faults, key)
+			}
+		})
+	}
+	s.faults[key] = f
+}
+
+func (s *socket) Drop(id ID, t int) {
+	s.inject(Fault{Type: FaultDrop, To: id}, t)
+}
+
+func (s *socket) Slow(id ID, delay int, t int) {
+	s.inject(Fault{Type: FaultSlow, To: id, Delay: delay}, t)
+}
+
+func (s *socket) Flaky(id ID, p float64, t int) {
+	s.inject(Fault{Type: FaultFlaky, To: id, P: p}, t)
+}
+
+func (s *socket) Duplicate(id ID, p float64, t int) {
+	s.inject(Fault{Type: FaultDuplicate, To: id, P: p}, t)
+}
+
+func (s *socket) Reorder(id ID, p float64, t int) {
+	s.inject(Fault{Type: FaultReorder, To: id, P: p}, t)
+}
+
+// Partition cuts off all peers as one fault, partitions overlap and heal apart
+func (s *socket) Partition(ids []ID, t int) {
+	s.inject(Fault{Type: FaultPartition, Peers: append([]ID(nil), ids...)}, t)
+}
+
+func (s *socket) Crash(t int) {
+	s.inject(Fault{Type: FaultCrash}, t)
+}
+
+// Faults returns active faults in order of type, peer and id
+func (s *socket) Faults() []Fault {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	faults := make([]Fault, 0, len(s.faults))
+	for _, f := range s.faults {
+		faults = append(faults, f)
+	}
+	sort.Slice(faults, func(i, j int) bool {
+		if faults[i].Type != faults[j].Type {
+			return faults[i].Type < faults[j].Type
+		}
+		if faults[i].To != faults[j].To {
+			return faults[i].To < faults[j].To
+		}
+		return faults[i].ID < faults[j].ID
+	})
+	return faults
+}
+
+// Heal removes all faults
+func (s *socket) Heal() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = make(map[faultKey]Fault)
+}
+
+// HealFault removes the fault of type, peer and id of given fault
+func (s *socket) HealFault(f Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.faults, f.key())
+}
+
+// partitioned checks if messages to peer are cut off by any partition, caller holds the lock
+func (s *socket) partitioned(to ID) bool {
+	for _, f := range s.faults {
+		if f.Type != FaultPartition {
+			continue
+		}
+		for _, id := range f.Peers {
+			if id == to {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package paxi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// faultyNodes creates two simulated nodes, node 1.2 records every received ping
+func faultyNodes(seed int64) (*Simulator, *node, *[]ping, func()) {
+	c := config
+	config.Addrs = map[ID]string{"1.1": "tcp://127.0.0.1:1801", "1.2": "tcp://127.0.0.1:1802"}
+	sim := Simulate(seed)
+	n := NewNode("1.1").(*node)
+	received := make([]ping, 0)
+	peer := NewNode("1.2")
+	peer.Register(ping{}, func(p ping) {
+		received = append(received, p)
+	})
+	return sim, n, &received, func() {
+		config = c
+		simulator = nil
+	}
+}
+
+func TestFaultDuplicateReorder(t *testing.T) {
+	sim, n, received, done := faultyNodes(1)
+	defer done()
+
+	n.Duplicate("1.2", 1, 0)
+	n.Send("1.2", ping{N: 0})
+	sim.Run(time.Second)
+	if len(*received) != 2 {
+		t.Fatalf("expected duplicated message, got %v", *received)
+	}
+
+	n.Heal()
+	n.Reorder("1.2", 1, 0)
+	*received = (*received)[:0]
+	for i := 0; i < 10; i++ {
+		n.Send("1.2", ping{N: i})
+	}
+	sim.Run(2 * time.Second)
+	if len(*received) != 10 {
+		t.Fatalf("expected 10 messages, got %d", len(*received))
+	}
+	ordered := true
+	for i, p := range *received {
+		ordered = ordered && p.N == i
+	}
+	if ordered {
+		t.Error("expected reordered messages")
+	}
+}
+
+func TestFaultExpireAndHeal(t *testing.T) {
+	sim, n, received, done := faultyNodes(1)
+	defer done()
+
+	n.Drop("1.2", 2)
+	n.Slow("1.2", 100, 0)
+	if f := n.Faults(); len(f) != 2 || f[0].Type != FaultDrop || f[0].Until != sim.Now().Add(2*time.Second) {
+		t.Fatalf("unexpected faults %+v", f)
+	}
+	n.Send("1.2", ping{N: 1})
+	sim.Run(3 * time.Second)
+	if len(*received) != 0 {
+		t.Errorf("expected dropped message, got %v", *received)
+	}
+	if f := n.Faults(); len(f) != 1 || f[0].Type != FaultSlow {
+		t.Errorf("expected only slow fault after drop expired, got %+v", f)
+	}
+	n.Heal()
+	if f := n.Faults(); len(f) != 0 {
+		t.Errorf("expected no fault after heal, got %+v", f)
+	}
+}
+
+func TestHTTPFault(t *testing.T) {
+	_, n, _, done := faultyNodes(1)
+	defer done()
+
+	w := httptest.NewRecorder()
+	n.handleFault(w, httptest.NewRequest(http.MethodGet, "/flaky?id=1.2&p=2&t=1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request of invalid chance, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	n.handleFault(w, httptest.NewRequest(http.MethodGet, "/reorder?id=1.2&p=0.5&t=0", nil))
+	if f := n.Faults(); w.Code != http.StatusOK || len(f) != 1 || f[0].Type != FaultReorder || f[0].P != 0.5 {
+		t.Errorf("unexpected faults %+v", f)
+	}
+	n.handleHeal(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/heal", nil))
+	if f := n.Faults(); len(f) != 0 {
+		t.Errorf("expected no fault after heal, got %+v", f)
+	}
+}
+
+func TestFaultPartition(t *testing.T) {
+	sim, n, received, done := faultyNodes(1)
+	defer done()
+
+	w := httptest.NewRecorder()
+	n.handleFault(w, httptest.NewRequest(http.MethodGet, "/partition?id=1.2&id=1.3&t=0", nil))
+	if f := n.Faults(); w.Code != http.StatusOK || len(f) != 1 || f[0].Type != FaultPartition || len(f[0].Peers) != 2 {
+		t.Fatalf("expected one partition fault, got %+v", f)
+	}
+	n.Send("1.2", ping{N: 1})
+	sim.Run(time.Second)
+	if len(*received) != 0 {
+		t.Errorf("expected message dropped by partition, got %v", *received)
+	}
+
+	// overlapping partition heals apart
+	n.Partition([]ID{"1.2"}, 1)
+	n.Slow("1.2", 100, 0)
+	sim.Run(2 * time.Second)
+	if f := n.Faults(); len(f) != 2 || f[0].Type != FaultPartition || len(f[0].Peers) != 2 {
+		t.Fatalf("expected first partition kept after second expired, got %+v", f)
+	}
+	id := n.Faults()[0].ID
+	n.handleHeal(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/heal?type=partition&fault="+strconv.Itoa(id), nil))
+	if f := n.Faults(); len(f) != 1 || f[0].Type != FaultSlow {
+		t.Errorf("expected only slow fault after partition healed, got %+v", f)
+	}
+	n.Send("1.2", ping{N: 2})
+	sim.Run(4 * time.Second)
+	if len(*received) != 1 {
+		t.Errorf("expected message after partition healed, got %v", *received)
+	}
+}
@@ -7,41 +7,46 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // History client operation history mapped by key
 type History struct {
 	sync.RWMutex
-	shard      map[int][]*operation
+	shard      map[Key][]*operation
 	operations []*operation
+	faults     map[*operation][]string // tags of faults active during operation
 }
 
 // NewHistory creates a History map
 func NewHistory() *History {
 	return &History{
-		shard:      make(map[int][]*operation),
+		shard:      make(map[Key][]*operation),
 		operations: make([]*operation, 0),
+		faults:     make(map[*operation][]string),
 	}
 }
 
 // Add puts an operation in History
-func (h *History) Add(key int, input, output interface{}, start, end int64) {
+func (h *History) Add(key Key, input, output interface{}, start, end int64) {
 	h.Lock()
 	defer h.Unlock()
 	if _, exists := h.shard[key]; !exists {
 		h.shard[key] = make([]*operation, 0)
 	}
-	o := &operation{input, output, start, end}
+	o := &operation{input: input, output: output, start: start, end: end}
 	h.shard[key] = append(h.shard[key], o)
 	h.operations = append(h.operations, o)
 }
 
 // AddOperation adds the operation
-func (h *History) AddOperation(key int, o *operation) {
+func (h *History) AddOperation(key Key, o *operation) {
 	h.Lock()
 	defer h.Unlock()
 	if _, exists := h.shard[key]; !exists {
@@ -51,8 +56,43 @@ func (h *History) AddOperation(key int, o *operation) {
 	h.operations = append(h.operations, o)
 }
 
+// tag tags operation with active faults
+func (h *History) tag(o *operation, faults []string) {
+	if len(faults) == 0 {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+	h.faults[o] = faults
+}
+
+// Unavailability returns the longest time between completions of successful operations
+func (h *History) Unavailability() time.Duration {
+	h.RLock()
+	ends := make([]int64, 0, len(h.operations))
+	for _, o := range h.operations {
+		if o.end != math.MaxInt64 {
+			ends = append(ends, o.end)
+		}
+	}
+	h.RUnlock()
+	sort.Slice(ends, func(i, j int) bool { return ends[i] < ends[j] })
+	var longest int64
+	for i := 1; i < len(ends); i++ {
+		if d := ends[i] - ends[i-1]; d > longest {
+			longest = d
+		}
+	}
+	return time.Duration(longest)
+}
+
 // Linearizable concurrently checks if each partition of the history is linearizable and returns the total number of anomaly reads
 func (h *History) Linearizable() int {
+	return len(h.anomalies())
+}
+
+// anomalies returns anomaly reads of all partitions of the history
+func (h *History) anomalies() []*operation {
 	anomalies := make(chan []*operation)
 	h.RLock()
 	defer h.RUnlock()
@@ -62,12 +102,11 @@ func (h *History) Linearizable() int {
 			anomalies <- c.linearizable(p)
 		}(partition)
 	}
-	sum := 0
+	all := make([]*operation, 0)
 	for range h.shard {
-		a := <-anomalies
-		sum += len(a)
+		all = append(all, <-anomalies...)
 	}
-	return sum
+	return all
 }
 
 // WriteFile writes entire operation history into file
@@ -90,7 +129,7 @@ func (h *History) WriteFile(path string) error {
 	for _, o := range h.operations {
 		start := float64(o.start) / 1000000000.0
 		end := float64(o.end) / 1000000000.0
-		fmt.Fprintf(w, "%v,%v,%f,%f\n", o.input, o.output, start, end)
+		fmt.Fprintf(w, "%v,%v,%f,%f,%s\n", o.input, o.output, start, end, strings.Join(h.faults[o], ";"))
 		latency += end - start
 		throughput++
 		if end > s {
@@ -104,7 +143,7 @@ func (h *History) WriteFile(path string) error {
 	}
 
 	// for k, ops := range h.shard {
-	// 	fmt.Fprintf(w, "key=%d\n", k)
+	// 	fmt.Fprintf(w, "key=%v\n", k)
 	// 	for _, o := range ops {
 	// 		fmt.Fprintln(w, o)
 	// 	}
@@ -135,10 +174,7 @@ func (h *History) ReadFile(path string) error {
 		}
 
 		// get id / key
-		id, err := strconv.Atoi(record[0])
-		if err != nil {
-			return err
-		}
+		key := Key(record[0])
 
 		operation := new(operation)
 
@@ -172,7 +208,7 @@ func (h *History) ReadFile(path string) error {
 		}
 		operation.end = end
 
-		h.AddOperation(id, operation)
+		h.AddOperation(key, operation)
 	}
 
 	return file.Close()
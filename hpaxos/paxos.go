@@ -277,12 +277,13 @@ func (p *Paxos) exec() {
 			break
 		}
 
-		value := p.Execute(e.command)
+		value, err := p.Execute(e.command)
 		if e.request != nil {
 			reply := paxi.Reply{
 				Command:    e.command,
 				Value:      value,
 				Properties: make(map[string]string),
+				Err:        err,
 			}
 			e.request.Reply(reply)
 			e.request = nil
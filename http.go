@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ailidani/paxi/log"
@@ -14,37 +15,94 @@ import (
 
 // http request header names
 const (
-	HTTPClientID  = "Id"
-	HTTPCommandID = "Cid"
-	HTTPTimestamp = "Timestamp"
-	HTTPNodeID    = "Id"
+	HTTPClientID    = "Id"
+	HTTPCommandID   = "Cid"
+	HTTPSession     = "Session"
+	HTTPTimestamp   = "Timestamp"
+	HTTPNodeID      = "Id"
+	HTTPIfMatch     = "If-Match"      // expected current value of compare and swap
+	HTTPIfNoneMatch = "If-None-Match" // "*" for compare and swap that requires key not exists
+	HTTPTTL         = "Ttl"           // time to live of key as duration string, e.g. "10s"
+	HTTPLeader      = "Leader"        // id of leader that redirected request should be sent to
 )
 
-// serve serves the http REST API request from clients
-func (n *node) http() {
+// KeyPath is the url path prefix of key-value API followed by the key,
+// keys can also follow root path unless they collide with other API paths
+const KeyPath = "/kv/"
+
+// ErrRedirect is replied by protocol to request that client should resend to leader in HTTPLeader property
+var ErrRedirect = Error("redirect to leader")
+
+// handler routes http REST API requests of node, crashed node serves only fault injection and heal requests
+func (n *node) handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", n.handleRoot)
+	mux.HandleFunc(KeyPath, n.handleRoot)
 	mux.HandleFunc("/history", n.handleHistory)
-	mux.HandleFunc("/crash", n.handleCrash)
-	mux.HandleFunc("/drop", n.handleDrop)
+	mux.HandleFunc("/scan", n.handleScan)
+	mux.HandleFunc("/txn", n.handleTxn)
+	mux.HandleFunc("/watch", n.handleWatch)
+	mux.HandleFunc("/peers", n.handlePeers)
+	mux.HandleFunc("/load", n.handleLoad)
+	admin := map[string]bool{"/faults": true, "/heal": true}
+	for _, fault := range []string{FaultCrash, FaultDrop, FaultSlow, FaultFlaky, FaultDuplicate, FaultReorder, FaultPartition} {
+		mux.HandleFunc("/"+fault, n.handleFault)
+		admin["/"+fault] = true
+	}
+	mux.HandleFunc("/faults", n.handleFaults)
+	mux.HandleFunc("/heal", n.handleHeal)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.crashed() && !admin[r.URL.Path] {
+			http.Error(w, ErrCrashed.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// serve serves the http REST API request from clients
+func (n *node) http() {
 	// http string should be in form of ":8080"
 	url, err := url.Parse(config.HTTPAddrs[n.id])
 	if err != nil {
 		log.Fatal("http url parse error: ", err)
 	}
 	port := ":" + url.Port()
-	n.server = &http.Server{
+	server := &http.Server{
 		Addr:    port,
-		Handler: mux,
+		Handler: n.handler(),
+	}
+	n.Lock()
+	if n.stopped {
+		// node stopped before serving
+		n.Unlock()
+		return
+	}
+	n.server = server
+	n.Unlock()
+	if url.Scheme == "https" {
+		// clients must present certificate signed by configured CA
+		server.TLSConfig, err = tlsConfig(n.id)
+		if err != nil {
+			log.Fatal("https config error: ", err)
+		}
+		log.Info("https server starting on ", port)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Info("http server starting on ", port)
+		err = server.ListenAndServe()
+	}
+	// server is closed by stop
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
 	}
-	log.Info("http server starting on ", port)
-	log.Fatal(n.server.ListenAndServe())
 }
 
 func (n *node) handleRoot(w http.ResponseWriter, r *http.Request) {
 	var req Request
 	var cmd Command
 	var err error
+	var cas bool
 
 	// get all http headers
 	req.Properties = make(map[string]string)
@@ -60,19 +118,42 @@ func (n *node) handleRoot(w http.ResponseWriter, r *http.Request) {
 			}
 			continue
 		}
+		if k == HTTPSession {
+			cmd.Session, err = strconv.ParseInt(r.Header.Get(HTTPSession), 10, 64)
+			if err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+		if k == HTTPIfMatch {
+			cas = true
+			cmd.Expect = Value(r.Header.Get(HTTPIfMatch))
+			continue
+		}
+		if k == HTTPIfNoneMatch {
+			cas = true
+			continue
+		}
+		if k == HTTPTTL {
+			cmd.TTL, err = time.ParseDuration(r.Header.Get(HTTPTTL))
+			if err != nil || cmd.TTL < 0 {
+				http.Error(w, "invalid ttl", http.StatusBadRequest)
+				return
+			}
+			continue
+		}
 		req.Properties[k] = r.Header.Get(k)
 	}
 
 	// get command key and value
-	if len(r.URL.Path) > 1 {
-		i, err := strconv.Atoi(r.URL.Path[1:])
-		if err != nil {
-			http.Error(w, "invalid path", http.StatusBadRequest)
-			log.Error(err)
-			return
-		}
-		cmd.Key = Key(i)
-		if r.Method == http.MethodPut || r.Method == http.MethodPost {
+	key := r.URL.Path[1:]
+	if strings.HasPrefix(r.URL.Path, KeyPath) {
+		key = r.URL.Path[len(KeyPath):]
+	}
+	if len(key) > 0 {
+		cmd.Key = Key(key)
+		switch r.Method {
+		case http.MethodPut, http.MethodPost:
 			body, err := ioutil.ReadAll(r.Body)
 			if err != nil {
 				log.Error("error reading body: ", err)
@@ -80,6 +161,23 @@ func (n *node) handleRoot(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			cmd.Value = Value(body)
+			cmd.Op = OpPut
+			switch r.URL.Query().Get("op") {
+			case "":
+			case "increment":
+				cmd.Op = OpIncrement
+			case "append":
+				cmd.Op = OpAppend
+			default:
+				http.Error(w, "invalid operation", http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			cmd.Op = OpDelete
+		}
+		// conditional put or delete
+		if cas && (cmd.Op == OpPut || cmd.Op == OpDelete) {
+			cmd.Op = OpCAS
 		}
 	} else {
 		body, err := ioutil.ReadAll(r.Body)
@@ -91,17 +189,44 @@ func (n *node) handleRoot(w http.ResponseWriter, r *http.Request) {
 		json.Unmarshal(body, &cmd)
 	}
 
+	if cmd.Timestamp == 0 {
+		cmd.Timestamp = time.Now().UnixNano()
+	}
 	req.Command = cmd
+	n.submit(w, r, req)
+}
+
+// submit passes the request to replica and writes the reply to client,
+// client is redirected to leader if protocol replies ErrRedirect
+func (n *node) submit(w http.ResponseWriter, r *http.Request, req Request) {
 	req.Timestamp = time.Now().UnixNano()
 	req.NodeID = n.id // TODO does this work when forward twice
 	req.c = make(chan Reply, 1)
 
-	n.MessageChan <- req
-
-	reply := <-req.c
+	halt := n.halt()
+	if !n.admit(req) {
+		overloaded(w)
+		return
+	}
+	var reply Reply
+	select {
+	case reply = <-req.c:
+	case <-halt:
+		reply = Reply{Command: req.Command, Err: n.err()}
+	}
+	n.done()
 
+	if reply.Err == ErrOverloaded {
+		overloaded(w)
+		return
+	}
+	if leader := ID(reply.Properties[HTTPLeader]); reply.Err == ErrRedirect && config.HTTPAddrs[leader] != "" {
+		w.Header().Set(HTTPLeader, string(leader))
+		http.Redirect(w, r, config.HTTPAddrs[leader]+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+		return
+	}
 	if reply.Err != nil {
-		http.Error(w, reply.Err.Error(), http.StatusInternalServerError)
+		http.Error(w, reply.Err.Error(), status(reply.Err))
 		return
 	}
 
@@ -111,52 +236,254 @@ func (n *node) handleRoot(w http.ResponseWriter, r *http.Request) {
 	for k, v := range reply.Properties {
 		w.Header().Set(k, v)
 	}
+	if db, ok := n.StateMachine.(Database); ok && reply.Command.Operation() == OpGet {
+		if ttl := db.TTL(reply.Command.Key); ttl > 0 {
+			w.Header().Set(HTTPTTL, ttl.String())
+		}
+	}
 
-	_, err = io.WriteString(w, string(reply.Value))
+	_, err := io.WriteString(w, string(reply.Value))
 	if err != nil {
 		log.Error(err)
 	}
 }
 
-func (n *node) handleHistory(w http.ResponseWriter, r *http.Request) {
+// status returns http status code of command error
+func status(err error) int {
+	switch err {
+	case ErrCASFailed:
+		return http.StatusPreconditionFailed
+	case ErrNotSupported:
+		return http.StatusNotImplemented
+	}
+	if _, ok := err.(Error); ok {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// handleTxn serves POST /txn with JSON list of commands executed atomically,
+// replies JSON list of command results in order
+func (n *node) handleTxn(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var cmds []Command
+	err := json.NewDecoder(r.Body).Decode(&cmds)
+	if err != nil || len(cmds) == 0 {
+		http.Error(w, "invalid transaction", http.StatusBadRequest)
+		return
+	}
+	cid, _ := strconv.Atoi(r.Header.Get(HTTPCommandID))
+	session, _ := strconv.ParseInt(r.Header.Get(HTTPSession), 10, 64)
+	for i := range cmds {
+		cmds[i].ClientID = ID(r.Header.Get(HTTPClientID))
+		cmds[i].CommandID = cid
+		cmds[i].Session = session
+	}
+
+	t := Transaction{
+		Commands:  cmds,
+		Timestamp: time.Now().UnixNano(),
+		c:         make(chan TransactionReply, 1),
+	}
+	halt := n.halt()
+	if !n.admit(t) {
+		overloaded(w)
+		return
+	}
+	var reply TransactionReply
+	select {
+	case reply = <-t.c:
+	case <-halt:
+		reply = TransactionReply{Err: n.err()}
+	}
+	n.done()
+
+	if reply.Err == ErrOverloaded {
+		overloaded(w)
+		return
+	}
+	if reply.Err != nil {
+		http.Error(w, reply.Err.Error(), status(reply.Err))
+		return
+	}
+	w.Header().Set(HTTPClientID, r.Header.Get(HTTPClientID))
+	w.Header().Set(HTTPCommandID, strconv.Itoa(cid))
+	err = json.NewEncoder(w).Encode(reply.Values)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleScan serves GET /scan?from=&to=&limit= as a linearizable scan command
+func (n *node) handleScan(w http.ResponseWriter, r *http.Request) {
+	var err error
+	q := r.URL.Query()
+	limit := 0
+	if l := q.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	cmd := NewScan(Key(q.Get("from")), Key(q.Get("to")), limit)
+	cmd.Timestamp = time.Now().UnixNano()
+	cmd.ClientID = ID(r.Header.Get(HTTPClientID))
+	if cid := r.Header.Get(HTTPCommandID); cid != "" {
+		cmd.CommandID, err = strconv.Atoi(cid)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	n.submit(w, r, Request{
+		Command:    cmd,
+		Properties: make(map[string]string),
+	})
+}
+
+// handleWatch serves GET /watch?key=&prefix= as chunked stream of newline delimited JSON events,
+// stream ends when client disconnects or falls behind
+func (n *node) handleWatch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := Key(q.Get("key"))
+	prefix := q.Get("prefix") == "true"
+	if key == "" && !prefix {
+		http.Error(w, "invalide key", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	watch := n.watchers.subscribe(key, prefix)
+	defer n.watchers.unsubscribe(watch)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(HTTPNodeID, string(n.id))
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case e, ok := <-watch.c:
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(e); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handlePeers serves GET /peers with outbound connection state of every peer and udp datagram counters
+func (n *node) handlePeers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set(HTTPNodeID, string(n.id))
-	k, err := strconv.Atoi(r.URL.Query().Get("key"))
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(n.Peers())
 	if err != nil {
 		log.Error(err)
+	}
+}
+
+func (n *node) handleHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(HTTPNodeID, string(n.id))
+	k := r.URL.Query().Get("key")
+	if k == "" {
 		http.Error(w, "invalide key", http.StatusBadRequest)
 		return
 	}
-	h := n.Database.History(Key(k))
+	db, ok := n.StateMachine.(Database)
+	if !ok {
+		http.Error(w, "history not supported by state machine", http.StatusNotImplemented)
+		return
+	}
+	h := db.History(Key(k))
 	b, _ := json.Marshal(h)
-	_, err = w.Write(b)
+	_, err := w.Write(b)
 	if err != nil {
 		log.Error(err)
 	}
 }
 
-func (n *node) handleCrash(w http.ResponseWriter, r *http.Request) {
-	t, err := strconv.Atoi(r.URL.Query().Get("t"))
+// handleFault injects fault of path /crash, /drop, /slow, /flaky, /duplicate, /reorder or /partition for t seconds,
+// to messages sent to peer id, with delay d in ms of slow fault and chance p of flaky, duplicate and reorder faults,
+// partition cuts off every peer of repeated id parameter
+func (n *node) handleFault(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	t, err := strconv.Atoi(q.Get("t"))
 	if err != nil {
 		log.Error(err)
 		http.Error(w, "invalide time", http.StatusBadRequest)
 		return
 	}
-	n.Socket.Crash(t)
-	// timer := time.NewTimer(time.Duration(t) * time.Second)
-	// go func() {
-	// 	n.server.Close()
-	// 	<-timer.C
-	// 	log.Error(n.server.ListenAndServe())
-	// }()
+	fault := strings.TrimPrefix(r.URL.Path, "/")
+	id := ID(q.Get("id"))
+	if fault != FaultCrash && id == "" {
+		http.Error(w, "invalide id", http.StatusBadRequest)
+		return
+	}
+	var p float64
+	if fault == FaultFlaky || fault == FaultDuplicate || fault == FaultReorder {
+		p, err = strconv.ParseFloat(q.Get("p"), 64)
+		if err != nil || p < 0 || p > 1 {
+			http.Error(w, "invalide chance", http.StatusBadRequest)
+			return
+		}
+	}
+	switch fault {
+	case FaultCrash:
+		n.Crash(t)
+	case FaultDrop:
+		n.Drop(id, t)
+	case FaultSlow:
+		d, err := strconv.Atoi(q.Get("d"))
+		if err != nil {
+			http.Error(w, "invalide delay", http.StatusBadRequest)
+			return
+		}
+		n.Slow(id, d, t)
+	case FaultFlaky:
+		n.Flaky(id, p, t)
+	case FaultDuplicate:
+		n.Duplicate(id, p, t)
+	case FaultReorder:
+		n.Reorder(id, p, t)
+	case FaultPartition:
+		ids := make([]ID, 0)
+		for _, id := range q["id"] {
+			ids = append(ids, ID(id))
+		}
+		n.Partition(ids, t)
+	}
 }
 
-func (n *node) handleDrop(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	t, err := strconv.Atoi(r.URL.Query().Get("t"))
+// handleFaults serves GET /faults with active faults of node
+func (n *node) handleFaults(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(HTTPNodeID, string(n.id))
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(n.Faults())
 	if err != nil {
 		log.Error(err)
-		http.Error(w, "invalide time", http.StatusBadRequest)
+	}
+}
+
+// handleHeal removes all faults of node, or only the fault of type, peer id and fault id of partition if type is given
+func (n *node) handleHeal(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if fault := q.Get("type"); fault != "" {
+		id, _ := strconv.Atoi(q.Get("fault"))
+		n.HealFault(Fault{Type: fault, To: ID(q.Get("id")), ID: id})
 		return
 	}
-	n.Drop(ID(id), t)
+	n.Heal()
 }
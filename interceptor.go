@@ -0,0 +1,142 @@
+package paxi
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// Handler handles one message
+type Handler func(m interface{})
+
+// Interceptor runs around message handling of node, it passes the message or a rewritten one to next,
+// delays by calling next later in the same call, or drops the message by not calling next
+type Interceptor func(m interface{}, next Handler)
+
+// Intercept adds interceptors to node before it runs, first added interceptor sees messages first
+func (n *node) Intercept(interceptors ...Interceptor) {
+	n.interceptors = append(n.interceptors, interceptors...)
+	h := Handler(n.dispatch)
+	for i := len(n.interceptors) - 1; i >= 0; i-- {
+		h = chain(n.interceptors[i], h)
+	}
+	n.chain = h
+}
+
+func chain(i Interceptor, next Handler) Handler {
+	return func(m interface{}) {
+		i(m, next)
+	}
+}
+
+// messageType returns name of message type as used by Register
+func messageType(m interface{}) string {
+	return reflect.TypeOf(m).String()
+}
+
+// Logger logs every message handled by node id
+func Logger(id ID) Interceptor {
+	return func(m interface{}, next Handler) {
+		log.Debugf("node %v handle %s %+v", id, messageType(m), m)
+		next(m)
+	}
+}
+
+// DropType drops messages of the same types as given messages
+func DropType(msgs ...interface{}) Interceptor {
+	types := make(map[string]bool)
+	for _, m := range msgs {
+		types[messageType(m)] = true
+	}
+	return func(m interface{}, next Handler) {
+		if !types[messageType(m)] {
+			next(m)
+		}
+	}
+}
+
+// Counters counts handled messages per type
+type Counters struct {
+	sync.RWMutex
+	counts map[string]int64
+}
+
+// NewCounters creates empty counters, use its Intercept method as interceptor
+func NewCounters() *Counters {
+	return &Counters{counts: make(map[string]int64)}
+}
+
+// Intercept counts message then passes it on
+func (c *Counters) Intercept(m interface{}, next Handler) {
+	c.Lock()
+	c.counts[messageType(m)]++
+	c.Unlock()
+	next(m)
+}
+
+// Get returns count of messages per type
+func (c *Counters) Get() map[string]int64 {
+	c.RLock()
+	defer c.RUnlock()
+	counts := make(map[string]int64, len(c.counts))
+	for t, n := range c.counts {
+		counts[t] = n
+	}
+	return counts
+}
+
+// Latency is handling time of one message type
+type Latency struct {
+	Count int64
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Mean returns average handling time
+func (l Latency) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Total / time.Duration(l.Count)
+}
+
+// Latencies measures handling time of messages per type by the rest of the chain and protocol handler
+type Latencies struct {
+	sync.RWMutex
+	latencies map[string]Latency
+}
+
+// NewLatencies creates empty latencies, use its Intercept method as interceptor
+func NewLatencies() *Latencies {
+	return &Latencies{latencies: make(map[string]Latency)}
+}
+
+// Intercept measures time of passing message on
+func (l *Latencies) Intercept(m interface{}, next Handler) {
+	start := time.Now()
+	next(m)
+	d := time.Since(start)
+	t := messageType(m)
+	l.Lock()
+	s := l.latencies[t]
+	s.Count++
+	s.Total += d
+	if d > s.Max {
+		s.Max = d
+	}
+	l.latencies[t] = s
+	l.Unlock()
+}
+
+// Get returns handling time per message type
+func (l *Latencies) Get() map[string]Latency {
+	l.RLock()
+	defer l.RUnlock()
+	latencies := make(map[string]Latency, len(l.latencies))
+	for t, s := range l.latencies {
+		latencies[t] = s
+	}
+	return latencies
+}
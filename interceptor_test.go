@@ -0,0 +1,53 @@
+package paxi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInterceptor(t *testing.T) {
+	n := &node{id: "1.1", handles: make(map[string]reflect.Value)}
+	var handled []int
+	n.Register(ping{}, func(p ping) { handled = append(handled, p.N) })
+	n.Register(Recover{}, func(Recover) { t.Error("expected dropped message not handled") })
+
+	var order []string
+	counters := NewCounters()
+	latencies := NewLatencies()
+	n.Intercept(
+		func(m interface{}, next Handler) {
+			order = append(order, "outer")
+			next(m)
+		},
+		counters.Intercept,
+		latencies.Intercept,
+		DropType(Recover{}),
+		func(m interface{}, next Handler) {
+			order = append(order, "inner")
+			// rewrite message
+			if p, ok := m.(ping); ok {
+				p.N *= 10
+				m = p
+			}
+			next(m)
+		},
+	)
+
+	n.call(ping{N: 1})
+	n.call(Recover{})
+	n.call(ping{N: 2})
+
+	if !reflect.DeepEqual(handled, []int{10, 20}) {
+		t.Errorf("expected rewritten messages handled, got %v", handled)
+	}
+	if !reflect.DeepEqual(order, []string{"outer", "inner", "outer", "outer", "inner"}) {
+		t.Errorf("unexpected order of interceptors %v", order)
+	}
+	counts := counters.Get()
+	if counts["paxi.ping"] != 2 || counts["paxi.Recover"] != 1 {
+		t.Errorf("unexpected counters %v", counts)
+	}
+	if l := latencies.Get()["paxi.ping"]; l.Count != 2 || l.Max < l.Mean() {
+		t.Errorf("unexpected latency %+v", l)
+	}
+}
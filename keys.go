@@ -0,0 +1,44 @@
+package paxi
+
+import "sort"
+
+// sortedKeys is an ordered set of keys for range scans
+type sortedKeys []Key
+
+// search returns the position of first key not less than k
+func (x sortedKeys) search(k Key) int {
+	return sort.Search(len(x), func(i int) bool { return x[i] >= k })
+}
+
+func (x *sortedKeys) insert(k Key) {
+	i := x.search(k)
+	if i < len(*x) && (*x)[i] == k {
+		return
+	}
+	*x = append(*x, "")
+	copy((*x)[i+1:], (*x)[i:])
+	(*x)[i] = k
+}
+
+func (x *sortedKeys) remove(k Key) {
+	i := x.search(k)
+	if i < len(*x) && (*x)[i] == k {
+		*x = append((*x)[:i], (*x)[i+1:]...)
+	}
+}
+
+// scan returns keys in range [from, to) in order
+// range is unbounded above if to is empty, and number of keys is unlimited if limit is not positive
+func (x sortedKeys) scan(from, to Key, limit int) []Key {
+	keys := make([]Key, 0)
+	for i := x.search(from); i < len(x); i++ {
+		if to != "" && x[i] >= to {
+			break
+		}
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+		keys = append(keys, x[i])
+	}
+	return keys
+}
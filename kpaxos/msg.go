@@ -48,7 +48,7 @@ type Accept struct {
 }
 
 func (a Accept) String() string {
-	return fmt.Sprintf("Accept {key=%d, %v}", a.Key, a.P2a)
+	return fmt.Sprintf("Accept {key=%v, %v}", a.Key, a.P2a)
 }
 
 // Accepted phase 2b
@@ -68,7 +68,7 @@ type Commit struct {
 }
 
 func (c Commit) String() string {
-	return fmt.Sprintf("Commit {key=%d, %v}", c.Key, c.P3)
+	return fmt.Sprintf("Commit {key=%v, %v}", c.Key, c.P3)
 }
 
 // LeaderChange switch leader
@@ -80,5 +80,5 @@ type LeaderChange struct {
 }
 
 func (l LeaderChange) String() string {
-	return fmt.Sprintf("LeaderChange {key=%d, from=%s, to=%s, bal=%d}", l.Key, l.From, l.To, l.Ballot)
+	return fmt.Sprintf("LeaderChange {key=%v, from=%s, to=%s, bal=%d}", l.Key, l.From, l.To, l.Ballot)
 }
@@ -1,15 +1,24 @@
 package kpaxos
 
 import (
+	"sort"
+	"strconv"
+
 	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/lib"
 	"github.com/ailidani/paxi/log"
 	"github.com/ailidani/paxi/paxos"
 )
 
+// ErrNoZone is returned when no configured zone can lead a key
+var ErrNoZone = paxi.Error("no zone configured to lead key")
+
 // Replica KPaxos replica with Paxos instance for each key
 type Replica struct {
 	paxi.Node
-	paxi map[paxi.Key]*paxos.Paxos
+	paxi  map[paxi.Key]*paxos.Paxos
+	zones []paxi.ID     // first node of every configured zone in zone order
+	ring  *lib.HashRing // consistent hash ring of zones
 
 	key paxi.Key // current working key
 }
@@ -18,6 +27,11 @@ func NewReplica(id paxi.ID) *Replica {
 	r := new(Replica)
 	r.Node = paxi.NewNode(id)
 	r.paxi = make(map[paxi.Key]*paxos.Paxos)
+	r.zones = zones(paxi.GetConfig().IDs())
+	r.ring = new(lib.HashRing)
+	for _, id := range r.zones {
+		r.ring.Insert(id, []byte(id))
+	}
 
 	r.Register(paxi.Request{}, r.handleRequest)
 	r.Register(Prepare{}, r.handlePrepare)
@@ -28,19 +42,40 @@ func NewReplica(id paxi.ID) *Replica {
 	return r
 }
 
-// TODO replace this with a consistent hash ring
-func index(key paxi.Key) paxi.ID {
-	if key < 200 {
-		return paxi.ID("1.1")
-	} else if key >= 200 && key < 400 {
-		return paxi.ID("2.1")
-	} else if key >= 400 && key < 600 {
-		return paxi.ID("3.1")
-	} else if key >= 600 && key < 800 {
-		return paxi.ID("4.1")
-	} else {
-		return paxi.ID("5.1")
+// zones returns the first node of every zone in ids, ordered by zone
+func zones(ids []paxi.ID) []paxi.ID {
+	first := make(map[int]paxi.ID)
+	for _, id := range ids {
+		if f, exists := first[id.Zone()]; !exists || id.Node() < f.Node() {
+			first[id.Zone()] = id
+		}
+	}
+	zones := make([]paxi.ID, 0, len(first))
+	for _, id := range first {
+		zones = append(zones, id)
 	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Zone() < zones[j].Zone() })
+	return zones
+}
+
+// index returns the leader node of key, integer keys of benchmark key space are split
+// into equal ranges per zone that benchmark locality relies on, other keys are placed by consistent hashing
+func (r *Replica) index(key paxi.Key) (paxi.ID, error) {
+	if len(r.zones) == 0 {
+		return "", ErrNoZone
+	}
+	b := paxi.GetConfig().Benchmark
+	k, err := strconv.Atoi(string(key))
+	if err != nil || b.K <= 0 {
+		return r.ring.Get([]byte(key)).(paxi.ID), nil
+	}
+	i := (k - b.Min) * len(r.zones) / b.K
+	if i < 0 {
+		i = 0
+	} else if i >= len(r.zones) {
+		i = len(r.zones) - 1
+	}
+	return r.zones[i], nil
 }
 
 func (r *Replica) init(key paxi.Key) {
@@ -53,7 +88,12 @@ func (r *Replica) handleRequest(m paxi.Request) {
 	r.key = m.Command.Key
 	r.init(r.key)
 
-	leader := index(r.key)
+	leader, err := r.index(r.key)
+	if err != nil {
+		log.Errorf("Replica %s cannot place key %v: %v", r.ID(), r.key, err)
+		m.Reply(paxi.Reply{Command: m.Command, Err: err})
+		return
+	}
 	if leader == r.ID() {
 		r.paxi[r.key].HandleRequest(m)
 	} else {
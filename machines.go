@@ -0,0 +1,217 @@
+package paxi
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// built-in state machines interpret command value as text payload in form of "op arg"
+
+// parse splits the command payload into operation and argument
+func parse(v Value) (string, string) {
+	s := strings.SplitN(string(v), " ", 2)
+	if len(s) < 2 {
+		return s[0], ""
+	}
+	return s[0], s[1]
+}
+
+func encodeState(state interface{}) []byte {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(state)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func decodeState(snapshot []byte, state interface{}) error {
+	if len(snapshot) == 0 {
+		return nil
+	}
+	return gob.NewDecoder(bytes.NewReader(snapshot)).Decode(state)
+}
+
+// Counter is a state machine of integer counters per key
+// payload "add n" increases counter by n, "set n" sets counter to n,
+// any command returns the counter value after execution
+type Counter struct {
+	sync.RWMutex
+	counters map[Key]int64
+}
+
+// NewCounter creates an empty Counter state machine
+func NewCounter() *Counter {
+	return &Counter{counters: make(map[Key]int64)}
+}
+
+// Execute implements StateMachine interface
+func (c *Counter) Execute(cmd Command) (Value, error) {
+	c.Lock()
+	defer c.Unlock()
+	if cmd.Value != nil {
+		op, arg := parse(cmd.Value)
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, ErrNotInteger
+		}
+		switch op {
+		case "add":
+			c.counters[cmd.Key] += n
+		case "set":
+			c.counters[cmd.Key] = n
+		default:
+			return nil, ErrNotSupported
+		}
+	}
+	return Value(strconv.FormatInt(c.counters[cmd.Key], 10)), nil
+}
+
+// Snapshot implements StateMachine interface
+func (c *Counter) Snapshot() []byte {
+	c.RLock()
+	defer c.RUnlock()
+	return encodeState(c.counters)
+}
+
+// Restore implements StateMachine interface
+func (c *Counter) Restore(snapshot []byte) error {
+	counters := make(map[Key]int64)
+	if err := decodeState(snapshot, &counters); err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.counters = counters
+	return nil
+}
+
+// Queue is a state machine of FIFO queues per key
+// payload "push item" enqueues item and returns it, "pop" dequeues and returns the head,
+// read command returns the head without removing it
+type Queue struct {
+	sync.RWMutex
+	queues map[Key][]Value
+}
+
+// NewQueue creates an empty Queue state machine
+func NewQueue() *Queue {
+	return &Queue{queues: make(map[Key][]Value)}
+}
+
+// Execute implements StateMachine interface
+func (q *Queue) Execute(cmd Command) (Value, error) {
+	q.Lock()
+	defer q.Unlock()
+	queue := q.queues[cmd.Key]
+	if cmd.Value == nil {
+		if len(queue) == 0 {
+			return nil, nil
+		}
+		return queue[0], nil
+	}
+	op, arg := parse(cmd.Value)
+	switch op {
+	case "push":
+		q.queues[cmd.Key] = append(queue, Value(arg))
+		return Value(arg), nil
+	case "pop":
+		if len(queue) == 0 {
+			return nil, nil
+		}
+		head := queue[0]
+		if len(queue) == 1 {
+			delete(q.queues, cmd.Key)
+		} else {
+			q.queues[cmd.Key] = queue[1:]
+		}
+		return head, nil
+	case "len":
+		return Value(strconv.Itoa(len(queue))), nil
+	}
+	return nil, ErrNotSupported
+}
+
+// Snapshot implements StateMachine interface
+func (q *Queue) Snapshot() []byte {
+	q.RLock()
+	defer q.RUnlock()
+	return encodeState(q.queues)
+}
+
+// Restore implements StateMachine interface
+func (q *Queue) Restore(snapshot []byte) error {
+	queues := make(map[Key][]Value)
+	if err := decodeState(snapshot, &queues); err != nil {
+		return err
+	}
+	q.Lock()
+	defer q.Unlock()
+	q.queues = queues
+	return nil
+}
+
+// LockTable is a state machine of exclusive locks per key
+// payload "lock owner" acquires the lock if free, "unlock owner" releases the lock if held by owner,
+// any command returns the lock holder after execution
+type LockTable struct {
+	sync.RWMutex
+	locks map[Key]string
+}
+
+// NewLockTable creates an empty LockTable state machine
+func NewLockTable() *LockTable {
+	return &LockTable{locks: make(map[Key]string)}
+}
+
+// Execute implements StateMachine interface
+func (l *LockTable) Execute(cmd Command) (Value, error) {
+	l.Lock()
+	defer l.Unlock()
+	if cmd.Value != nil {
+		op, owner := parse(cmd.Value)
+		holder, locked := l.locks[cmd.Key]
+		switch op {
+		case "lock":
+			if !locked {
+				l.locks[cmd.Key] = owner
+			}
+		case "unlock":
+			if locked && holder == owner {
+				delete(l.locks, cmd.Key)
+			}
+		default:
+			return nil, ErrNotSupported
+		}
+	}
+	holder, locked := l.locks[cmd.Key]
+	if !locked {
+		return nil, nil
+	}
+	return Value(holder), nil
+}
+
+// Snapshot implements StateMachine interface
+func (l *LockTable) Snapshot() []byte {
+	l.RLock()
+	defer l.RUnlock()
+	return encodeState(l.locks)
+}
+
+// Restore implements StateMachine interface
+func (l *LockTable) Restore(snapshot []byte) error {
+	locks := make(map[Key]string)
+	if err := decodeState(snapshot, &locks); err != nil {
+		return err
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.locks = locks
+	return nil
+}
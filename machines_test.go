@@ -0,0 +1,55 @@
+package paxi
+
+import (
+	"testing"
+)
+
+func get(sm StateMachine, key Key, payload ...string) Value {
+	cmd := Command{Key: key}
+	if len(payload) > 0 {
+		cmd.Value = Value(payload[0])
+	}
+	v, _ := sm.Execute(cmd)
+	return v
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter()
+	c.Execute(Command{Key: "a", Value: Value("add 2")})
+	c.Execute(Command{Key: "a", Value: Value("add 3")})
+	if v := string(get(c, "a")); v != "5" {
+		t.Errorf("expected counter 5, got %s", v)
+	}
+
+	snapshot := c.Snapshot()
+	c.Execute(Command{Key: "a", Value: Value("set 0")})
+	c.Restore(snapshot)
+	if v := string(get(c, "a")); v != "5" {
+		t.Errorf("expected restored counter 5, got %s", v)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	q := NewQueue()
+	q.Execute(Command{Key: "q", Value: Value("push x")})
+	q.Execute(Command{Key: "q", Value: Value("push y")})
+	if v := string(get(q, "q", "pop")); v != "x" {
+		t.Errorf("expected head x, got %s", v)
+	}
+	if v := string(get(q, "q")); v != "y" {
+		t.Errorf("expected head y, got %s", v)
+	}
+}
+
+func TestLockTable(t *testing.T) {
+	l := NewLockTable()
+	l.Execute(Command{Key: "l", Value: Value("lock c1")})
+	if v := string(get(l, "l", "lock c2")); v != "c1" {
+		t.Errorf("expected holder c1, got %s", v)
+	}
+	l.Execute(Command{Key: "l", Value: Value("unlock c2")})
+	l.Execute(Command{Key: "l", Value: Value("unlock c1")})
+	if v := get(l, "l"); v != nil {
+		t.Errorf("expected free lock, got %s", v)
+	}
+}
@@ -2,6 +2,7 @@ package paxi
 
 import (
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 )
 
@@ -14,6 +15,9 @@ func init() {
 	gob.Register(TransactionReply{})
 	gob.Register(Register{})
 	gob.Register(Config{})
+	gob.Register(Error(""))
+	gob.Register(Batch{})
+	RegisterMessage(2, Batch{})
 }
 
 /***************************
@@ -25,12 +29,29 @@ type Request struct {
 	Command    Command
 	Properties map[string]string
 	Timestamp  int64
-	NodeID     ID         // forward by node
-	c          chan Reply // reply channel created by request receiver
+	NodeID     ID                    // forward by node
+	c          chan Reply            // reply channel created by request receiver
+	txn        chan TransactionReply // reply channel of transaction the request is converted from
 }
 
-// Reply replies to current client session
+// Reply replies to current client session, request without session is ignored
 func (r *Request) Reply(reply Reply) {
+	if r.txn != nil {
+		t := TransactionReply{
+			OK:        reply.Err == nil,
+			Commands:  r.Command.Commands(),
+			Timestamp: reply.Timestamp,
+			Err:       reply.Err,
+		}
+		if reply.Err == nil {
+			json.Unmarshal(reply.Value, &t.Values)
+		}
+		r.txn <- t
+		return
+	}
+	if r.c == nil {
+		return
+	}
 	r.c <- reply
 }
 
@@ -58,7 +79,7 @@ type Read struct {
 }
 
 func (r Read) String() string {
-	return fmt.Sprintf("Read {cid=%d, key=%d}", r.CommandID, r.Key)
+	return fmt.Sprintf("Read {cid=%d, key=%v}", r.CommandID, r.Key)
 }
 
 // ReadReply cid and value of reading key
@@ -82,9 +103,30 @@ type Transaction struct {
 
 // Reply replies to current client session
 func (t *Transaction) Reply(r TransactionReply) {
+	if t.c == nil {
+		return
+	}
 	t.c <- r
 }
 
+// Request converts transaction into request of a single transaction command,
+// the reply of request is converted and sent to transaction session
+func (t Transaction) Request() Request {
+	cmd := NewTxn(t.Commands)
+	cmd.Timestamp = t.Timestamp
+	if len(t.Commands) > 0 {
+		cmd.ClientID = t.Commands[0].ClientID
+		cmd.CommandID = t.Commands[0].CommandID
+		cmd.Session = t.Commands[0].Session
+	}
+	return Request{
+		Command:    cmd,
+		Properties: make(map[string]string),
+		Timestamp:  t.Timestamp,
+		txn:        t.c,
+	}
+}
+
 func (t Transaction) String() string {
 	return fmt.Sprintf("Transaction {cmds=%v}", t.Commands)
 }
@@ -93,6 +135,7 @@ func (t Transaction) String() string {
 type TransactionReply struct {
 	OK        bool
 	Commands  []Command
+	Values    []Value // result of every command in order
 	Timestamp int64
 	Err       error
 }
@@ -0,0 +1,193 @@
+package paxi
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ailidani/paxi/lib"
+	"github.com/ailidani/paxi/log"
+)
+
+// faults of nemesis schedule besides socket faults
+const (
+	FaultOneWay = "oneway" // drops messages from nodes to peers
+)
+
+// Nemesis is one fault of benchmark fault schedule injected through AdminClient
+type Nemesis struct {
+	At       int     // seconds since benchmark starts
+	Duration int     // seconds the fault lasts, until benchmark ends if 0
+	Fault    string  // crash, drop, slow, flaky, duplicate, reorder, partition or oneway
+	Nodes    []ID    // crashed nodes, senders of faulty messages or one side of partition
+	Zones    []int   // all nodes in zones are added to nodes
+	Peers    []ID    // receivers of faulty messages or other side of partition, all other nodes if empty
+	Delay    int     // delay in ms of slow fault
+	P        float64 // chance of flaky, duplicate and reorder faults
+}
+
+// String returns the tag of fault in history, e.g. crash[1.1]@10s
+func (n Nemesis) String() string {
+	return fmt.Sprintf("%s%v@%ds", n.Fault, n.nodes(), n.At)
+}
+
+// nodes returns nodes of fault including nodes in zones
+func (n Nemesis) nodes() []ID {
+	nodes := append([]ID(nil), n.Nodes...)
+	for _, z := range n.Zones {
+		for _, id := range config.IDs() {
+			if id.Zone() == z {
+				nodes = append(nodes, id)
+			}
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	return nodes
+}
+
+// peers returns peers of fault, all nodes not in fault nodes by default
+func (n Nemesis) peers(nodes []ID) []ID {
+	if len(n.Peers) > 0 {
+		return n.Peers
+	}
+	s := lib.NewSet()
+	for _, id := range nodes {
+		s.Add(id)
+	}
+	peers := make([]ID, 0)
+	for _, id := range config.IDs() {
+		if !s.Has(id) {
+			peers = append(peers, id)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+	return peers
+}
+
+// inject injects the fault through admin client
+func (n Nemesis) inject(admin AdminClient) {
+	nodes := n.nodes()
+	peers := n.peers(nodes)
+	t := n.Duration
+	switch n.Fault {
+	case FaultCrash:
+		for _, id := range nodes {
+			admin.Crash(id, t)
+		}
+	case FaultPartition:
+		admin.PartitionOneWay(t, nodes, peers)
+		admin.PartitionOneWay(t, peers, nodes)
+	case FaultOneWay:
+		admin.PartitionOneWay(t, nodes, peers)
+	case FaultDrop, FaultSlow, FaultFlaky, FaultDuplicate, FaultReorder:
+		for _, from := range nodes {
+			for _, to := range peers {
+				switch n.Fault {
+				case FaultDrop:
+					admin.Drop(from, to, t)
+				case FaultSlow:
+					admin.Slow(from, to, n.Delay, t)
+				case FaultFlaky:
+					admin.Flaky(from, to, n.P, t)
+				case FaultDuplicate:
+					admin.Duplicate(from, to, n.P, t)
+				case FaultReorder:
+					admin.Reorder(from, to, n.P, t)
+				}
+			}
+		}
+	default:
+		log.Errorf("unknown nemesis fault %s", n.Fault)
+	}
+}
+
+// activeFault is a fault of schedule in time since benchmark starts
+type activeFault struct {
+	name       string
+	start, end int64
+}
+
+// nemesis runs fault schedule of benchmark until done is closed, then heals all nodes
+func (b *Benchmark) nemesis(done <-chan struct{}) {
+	schedule := append([]Nemesis(nil), b.Nemesis...)
+	sort.SliceStable(schedule, func(i, j int) bool { return schedule[i].At < schedule[j].At })
+	defer b.Admin.Heal()
+	for _, n := range schedule {
+		select {
+		case <-done:
+			return
+		case <-time.After(time.Until(b.startTime.Add(time.Duration(n.At) * time.Second))):
+		}
+		log.Infof("nemesis injects %v", n)
+		start := time.Since(b.startTime).Nanoseconds()
+		n.inject(b.Admin)
+		end := int64(math.MaxInt64)
+		if n.Duration > 0 {
+			end = start + (time.Duration(n.Duration) * time.Second).Nanoseconds()
+		}
+		b.faultsLock.Lock()
+		b.faults = append(b.faults, activeFault{n.String(), start, end})
+		b.faultsLock.Unlock()
+	}
+	<-done
+}
+
+// activeFaults returns tags of faults active in time between start and end
+func (b *Benchmark) activeFaults(start, end int64) []string {
+	b.faultsLock.RLock()
+	defer b.faultsLock.RUnlock()
+	var faults []string
+	for _, f := range b.faults {
+		if f.start <= end && start <= f.end {
+			faults = append(faults, f.name)
+		}
+	}
+	return faults
+}
+
+// faultSummary logs latency and anomalies of operations grouped by active faults
+func faultSummary(h *History, anomalies []*operation) {
+	type summary struct {
+		ops, anomalies int
+		latency        time.Duration
+	}
+	summaries := make(map[string]*summary)
+	group := func(o *operation) *summary {
+		tag := strings.Join(h.faults[o], " ")
+		if tag == "" {
+			tag = "none"
+		}
+		if summaries[tag] == nil {
+			summaries[tag] = new(summary)
+		}
+		return summaries[tag]
+	}
+	h.RLock()
+	defer h.RUnlock()
+	for _, o := range h.operations {
+		if o.end == math.MaxInt64 {
+			continue
+		}
+		s := group(o)
+		s.ops++
+		s.latency += time.Duration(o.end - o.start)
+	}
+	for _, o := range anomalies {
+		group(o).anomalies++
+	}
+	tags := make([]string, 0, len(summaries))
+	for tag := range summaries {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		s := summaries[tag]
+		var mean time.Duration
+		if s.ops > 0 {
+			mean = s.latency / time.Duration(s.ops)
+		}
+		log.Infof("Faults [%s]: operations = %d, mean latency = %v, anomalies = %d", tag, s.ops, mean, s.anomalies)
+	}
+}
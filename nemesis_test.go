@@ -0,0 +1,93 @@
+package paxi
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAdmin records fault injections
+type fakeAdmin struct {
+	sync.Mutex
+	calls []string
+}
+
+func (a *fakeAdmin) record(format string, args ...interface{}) {
+	a.Lock()
+	defer a.Unlock()
+	a.calls = append(a.calls, fmt.Sprintf(format, args...))
+}
+
+func (a *fakeAdmin) Consensus(Key) bool         { return true }
+func (a *fakeAdmin) Crash(id ID, t int)         { a.record("crash %s %d", id, t) }
+func (a *fakeAdmin) Drop(from, to ID, t int)    { a.record("drop %s %s %d", from, to, t) }
+func (a *fakeAdmin) Slow(from, to ID, d, t int) { a.record("slow %s %s %d %d", from, to, d, t) }
+func (a *fakeAdmin) Flaky(from, to ID, p float64, t int) {
+	a.record("flaky %s %s %v %d", from, to, p, t)
+}
+func (a *fakeAdmin) Duplicate(from, to ID, p float64, t int) {
+	a.record("duplicate %s %s %v %d", from, to, p, t)
+}
+func (a *fakeAdmin) Reorder(from, to ID, p float64, t int) {
+	a.record("reorder %s %s %v %d", from, to, p, t)
+}
+func (a *fakeAdmin) Partition(t int, nodes ...ID)         { a.record("partition %d %v", t, nodes) }
+func (a *fakeAdmin) PartitionOneWay(t int, from, to []ID) { a.record("oneway %d %v %v", t, from, to) }
+func (a *fakeAdmin) Faults(ID) ([]Fault, error)           { return nil, nil }
+func (a *fakeAdmin) Heal(nodes ...ID)                     { a.record("heal %v", nodes) }
+
+// slowDB takes a millisecond for every operation
+type slowDB struct{}
+
+func (slowDB) Init() error           { return nil }
+func (slowDB) Stop() error           { return nil }
+func (slowDB) Read(Key) (int, error) { time.Sleep(time.Millisecond); return 0, nil }
+func (slowDB) Write(Key, int) error  { time.Sleep(time.Millisecond); return nil }
+
+func TestNemesis(t *testing.T) {
+	c := config
+	defer func() { config = c }()
+	config.Addrs = map[ID]string{"1.1": "", "1.2": "", "2.1": ""}
+	defer os.Remove("history.csv")
+	defer os.Remove("latency")
+
+	admin := new(fakeAdmin)
+	b := NewBenchmark(slowDB{})
+	b.Admin = admin
+	b.T = 1
+	b.LinearizabilityCheck = false
+	b.Nemesis = []Nemesis{
+		{At: 10, Fault: FaultCrash, Nodes: []ID{"1.1"}},
+		{At: 0, Fault: FaultPartition, Zones: []int{1}},
+		{At: 0, Duration: 5, Fault: FaultDuplicate, Nodes: []ID{"2.1"}, Peers: []ID{"1.1"}, P: 0.5},
+	}
+	b.Run()
+
+	expected := []string{
+		"oneway 0 [1.1 1.2] [2.1]",
+		"oneway 0 [2.1] [1.1 1.2]",
+		"duplicate 2.1 1.1 0.5 5",
+		"heal []",
+	}
+	if fmt.Sprint(admin.calls) != fmt.Sprint(expected) {
+		t.Errorf("expected injections %v, got %v", expected, admin.calls)
+	}
+
+	drop := new(fakeAdmin)
+	Nemesis{Duration: 5, Fault: FaultDrop, Nodes: []ID{"1.2"}, Peers: []ID{"2.1"}}.inject(drop)
+	if fmt.Sprint(drop.calls) != "[drop 1.2 2.1 5]" {
+		t.Errorf("expected drop fault injected, got %v", drop.calls)
+	}
+
+	tagged := 0
+	for _, o := range b.History.operations {
+		if len(b.History.faults[o]) == 2 {
+			tagged++
+		}
+	}
+	if tagged == 0 || tagged < len(b.History.operations)-b.Concurrency {
+		t.Errorf("expected operations tagged with 2 faults, got %d of %d", tagged, len(b.History.operations))
+	}
+}
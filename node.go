@@ -1,23 +1,36 @@
 package paxi
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"reflect"
 	"sync"
+	"time"
 
 	"github.com/ailidani/paxi/log"
 )
 
+// ErrStopped is returned to client of stopped node
+var ErrStopped = Error("node stopped")
+
 // Node is the primary access point for every replica
 // it includes networking, state machine and RESTful API server
 type Node interface {
 	Socket
-	Database
+	StateMachine
 	ID() ID
+	Clock() Clock
+	Durable() Durable
+	After(d time.Duration, m interface{}) Timer
+	Every(d time.Duration, m interface{}) Timer
 	Run()
+	RunContext(ctx context.Context) error
+	Stop()
 	Retry(r Request)
 	Forward(id ID, r Request)
 	Register(m interface{}, f interface{})
+	Intercept(interceptors ...Interceptor)
 }
 
 // node implements Node interface
@@ -25,31 +38,68 @@ type node struct {
 	id ID
 
 	Socket
-	Database
+	StateMachine
+	clock       Clock
 	MessageChan chan interface{}
 	handles     map[string]reflect.Value
 	server      *http.Server
 
+	interceptors []Interceptor
+	chain        Handler // interceptors around dispatch
+
 	sync.RWMutex
 	forwards map[string]*Request
+
+	watchers watchers
+	timers   timers
+	sessions sessions
+
+	admission // client request queue
+
+	down     bool           // node is crashed or stopped
+	life     int            // number of crashes, identifies recovery of the latest crash
+	stop     chan struct{}  // closed when node crashes or stops
+	stopped  bool           // node is stopped for good
+	exit     chan struct{}  // closed when node stops
+	running  sync.WaitGroup // goroutines of message handling
+	handling chan struct{}  // closed when the handle goroutine of current life exits
 }
 
-// NewNode creates a new Node object from configuration
+// NewNode creates a new Node object with the state machine from configuration
 func NewNode(id ID) Node {
-	return &node{
-		id:          id,
-		Socket:      NewSocket(id, config.Addrs),
-		Database:    NewDatabase(),
-		MessageChan: make(chan interface{}, config.ChanBufferSize),
-		handles:     make(map[string]reflect.Value),
-		forwards:    make(map[string]*Request),
+	return NewNodeWithStateMachine(id, NewStateMachine(id))
+}
+
+// NewNodeWithStateMachine creates a new Node object that replicates given state machine
+func NewNodeWithStateMachine(id ID, sm StateMachine) Node {
+	n := &node{
+		id:           id,
+		Socket:       NewSocket(id, config.Addrs),
+		StateMachine: sm,
+		clock:        wallClock{},
+		MessageChan:  make(chan interface{}, config.ChanBufferSize),
+		admission:    newAdmission(),
+		handles:      make(map[string]reflect.Value),
+		forwards:     make(map[string]*Request),
+		stop:         make(chan struct{}),
+		exit:         make(chan struct{}),
+	}
+	if simulator != nil {
+		n.clock = simulator
+		simulator.add(n)
 	}
+	return n
 }
 
 func (n *node) ID() ID {
 	return n.id
 }
 
+// Clock returns the clock of node, virtual clock in simulation
+func (n *node) Clock() Clock {
+	return n.clock
+}
+
 func (n *node) Retry(r Request) {
 	log.Debugf("node %v retry reqeust %v", n.id, r)
 	n.MessageChan <- r
@@ -65,53 +115,217 @@ func (n *node) Register(m interface{}, f interface{}) {
 	n.handles[t.String()] = fn
 }
 
-// Run start and run the node
+// Run starts and runs the node until it is stopped, node in simulation is run by simulator
 func (n *node) Run() {
+	n.RunContext(context.Background())
+}
+
+// RunContext starts and runs the node until ctx is done or node is stopped,
+// node is stopped when ctx is done
+func (n *node) RunContext(ctx context.Context) error {
+	if n.clock == simulator {
+		log.Infof("node %v running in simulation", n.id)
+		return nil
+	}
+	n.RLock()
+	stopped := n.stopped
+	stop := n.stop
+	n.RUnlock()
+	if stopped {
+		return ErrStopped
+	}
 	log.Infof("node %v start running", n.id)
 	if len(n.handles) > 0 {
-		go n.handle()
-		go n.recv()
+		n.startHandle(stop)
+		n.goroutine(n.recv)
+	}
+	go n.http()
+	select {
+	case <-ctx.Done():
+		n.Stop()
+		return ctx.Err()
+	case <-n.exit:
+		return nil
 	}
-	n.http()
 }
 
-// recv receives messages from socket and pass to message channel
+// Stop shuts down http server, connections and message handling of node and waits for them to exit,
+// pending client requests fail with ErrStopped, a new node of the same id can run afterwards
+func (n *node) Stop() {
+	n.Lock()
+	if n.stopped {
+		n.Unlock()
+		return
+	}
+	n.stopped = true
+	if !n.down {
+		n.down = true
+		close(n.stop)
+	}
+	n.forwards = make(map[string]*Request)
+	server := n.server
+	n.server = nil
+	n.Unlock()
+
+	n.timers.stop()
+	if server != nil {
+		server.Close()
+	}
+	n.Socket.Close()
+	n.running.Wait()
+	n.fail(ErrStopped)
+	if c, ok := n.StateMachine.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Errorf("node %v cannot close state machine: %v", n.id, err)
+		}
+	}
+	close(n.exit)
+	log.Infof("node %v stopped", n.id)
+}
+
+// goroutine runs f in a goroutine that Stop waits for
+func (n *node) goroutine(f func()) {
+	n.running.Add(1)
+	go func() {
+		defer n.running.Done()
+		f()
+	}()
+}
+
+// startHandle runs the handle goroutine until stop is closed
+func (n *node) startHandle(stop <-chan struct{}) {
+	handling := make(chan struct{})
+	n.Lock()
+	n.handling = handling
+	n.Unlock()
+	n.goroutine(func() {
+		defer close(handling)
+		n.handle(stop)
+	})
+}
+
+// halt returns channel closed when node crashes or stops
+func (n *node) halt() <-chan struct{} {
+	n.RLock()
+	defer n.RUnlock()
+	return n.stop
+}
+
+// err returns error of client request failed by crash or stop
+func (n *node) err() error {
+	n.RLock()
+	defer n.RUnlock()
+	if n.stopped {
+		return ErrStopped
+	}
+	return ErrCrashed
+}
+
+// recv receives messages from socket and pass to message channel until socket is closed
 func (n *node) recv() {
 	for {
 		m := n.Recv()
+		if m == nil {
+			return
+		}
+		if n.crashed() {
+			continue
+		}
 		switch m := m.(type) {
 		case Request:
 			m.c = make(chan Reply, 1)
-			go func(r Request) {
-				n.Send(r.NodeID, <-r.c)
-			}(m)
-			n.MessageChan <- m
+			if !n.admit(m) {
+				n.Send(m.NodeID, Reply{Command: m.Command, Err: ErrOverloaded})
+				continue
+			}
+			go func(r Request, halt <-chan struct{}) {
+				select {
+				case reply := <-r.c:
+					n.Send(r.NodeID, reply)
+				case <-halt:
+				}
+				n.done()
+			}(m, n.halt())
 			continue
 
 		case Reply:
-			n.RLock()
-			r := n.forwards[m.Command.String()]
-			log.Debugf("node %v received reply %v", n.id, m)
-			n.RUnlock()
-			r.Reply(m)
+			n.reply(m)
 			continue
 		}
-		n.MessageChan <- m
+		// message is lost if node crashes or stops before handling it
+		select {
+		case n.MessageChan <- m:
+		case <-n.halt():
+		}
 	}
 }
 
-// handle receives messages from message channel and calls handle function using refection
-func (n *node) handle() {
+// reply passes reply of forwarded request to its client
+// reply of request forgotten by crash or stop is dropped
+func (n *node) reply(m Reply) {
+	n.Lock()
+	r, exists := n.forwards[m.Command.String()]
+	delete(n.forwards, m.Command.String())
+	n.Unlock()
+	log.Debugf("node %v received reply %v", n.id, m)
+	if !exists {
+		return
+	}
+	r.Reply(m)
+}
+
+// handle receives messages from message channel and client requests from request queue
+// and calls handle function using refection until stop is closed, protocol messages go first
+func (n *node) handle(stop <-chan struct{}) {
 	for {
-		msg := <-n.MessageChan
-		v := reflect.ValueOf(msg)
-		name := v.Type().String()
-		f, exists := n.handles[name]
-		if !exists {
-			log.Fatalf("no registered handle function for message type %v", name)
+		select {
+		case <-stop:
+			return
+		case m := <-n.MessageChan:
+			n.call(m)
+			continue
+		default:
+		}
+		select {
+		case <-stop:
+			return
+		case m := <-n.MessageChan:
+			n.call(m)
+		case m := <-n.requests:
+			n.call(m)
+		}
+	}
+}
+
+// call passes message through interceptors to its handle function
+func (n *node) call(msg interface{}) {
+	if e, ok := msg.(expiration); ok {
+		if !e.t.expire() {
+			return
 		}
-		f.Call([]reflect.Value{v})
+		msg = e.t.m
+	}
+	if n.chain != nil {
+		n.chain(msg)
+		return
+	}
+	n.dispatch(msg)
+}
+
+// dispatch calls handle function of message
+func (n *node) dispatch(msg interface{}) {
+	v := reflect.ValueOf(msg)
+	name := v.Type().String()
+	f, exists := n.handles[name]
+	if t, ok := msg.(Transaction); ok && !exists {
+		// protocol without transaction support replicates it as one command
+		v = reflect.ValueOf(t.Request())
+		f, exists = n.handles[v.Type().String()]
+	}
+	if !exists {
+		log.Fatalf("no registered handle function for message type %v", name)
 	}
+	f.Call([]reflect.Value{v})
 }
 
 /*
@@ -0,0 +1,85 @@
+package paxi
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// echo runs node 1.1 that replies key of every request
+func echo() (*node, context.CancelFunc, chan error) {
+	n := NewNodeWithStateMachine("1.1", NewDatabase("1.1")).(*node)
+	n.Register(Request{}, func(r Request) {
+		if r.Command.Key == "hang" {
+			return
+		}
+		r.Reply(Reply{Command: r.Command, Value: Value(r.Command.Key)})
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- n.RunContext(ctx) }()
+	return n, cancel, stopped
+}
+
+func httpGet(key string) (string, error) {
+	var err error
+	for i := 0; i < 50; i++ {
+		var res *http.Response
+		res, err = http.Get(config.HTTPAddrs["1.1"] + "/" + key)
+		if err == nil {
+			b, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			return string(b), nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return "", err
+}
+
+func TestNodeStopRestart(t *testing.T) {
+	c := config
+	defer func() { config = c }()
+	config.Addrs = map[ID]string{"1.1": "tcp://127.0.0.1:1760", "1.2": "tcp://127.0.0.1:1761"}
+	config.HTTPAddrs = map[ID]string{"1.1": "http://127.0.0.1:8190"}
+
+	n, cancel, stopped := echo()
+	if v, err := httpGet("a"); err != nil || v != "a" {
+		t.Fatalf("expected running node replies, got %q %v", v, err)
+	}
+
+	// pending request fails when node stops
+	pending := httptest.NewRecorder()
+	replied := make(chan bool)
+	go func() {
+		n.submit(pending, httptest.NewRequest(http.MethodGet, "/hang", nil), Request{Command: Command{Key: "hang"}})
+		replied <- true
+	}()
+	for n.Load().Pending == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-stopped; err != context.Canceled {
+		t.Errorf("expected run cancelled, got %v", err)
+	}
+	<-replied
+	if pending.Body.String() != ErrStopped.Error()+"\n" {
+		t.Errorf("expected pending request fails, got %d %s", pending.Code, pending.Body)
+	}
+	if err := n.RunContext(context.Background()); err != ErrStopped {
+		t.Errorf("expected stopped node cannot run again, got %v", err)
+	}
+
+	// the same id runs again in process
+	n, cancel, stopped = echo()
+	if v, err := httpGet("b"); err != nil || v != "b" {
+		t.Fatalf("expected restarted node replies, got %q %v", v, err)
+	}
+	n.Stop()
+	if err := <-stopped; err != nil {
+		t.Errorf("expected run returns after stop, got %v", err)
+	}
+	cancel()
+}
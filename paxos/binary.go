@@ -0,0 +1,71 @@
+package paxos
+
+import "github.com/ailidani/paxi"
+
+// MarshalBinary implements encoding.BinaryMarshaler for binary codec
+func (m P1a) MarshalBinary() ([]byte, error) {
+	w := new(paxi.BinaryWriter)
+	w.PutBallot(m.Ballot)
+	return w.Data(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for binary codec
+func (m *P1a) UnmarshalBinary(data []byte) error {
+	r := paxi.NewBinaryReader(data)
+	m.Ballot = r.Ballot()
+	return r.Err()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler for binary codec
+func (m P2a) MarshalBinary() ([]byte, error) {
+	w := new(paxi.BinaryWriter)
+	w.PutBallot(m.Ballot)
+	w.PutInt(int64(m.Slot))
+	w.PutCommand(m.Command)
+	return w.Data(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for binary codec
+func (m *P2a) UnmarshalBinary(data []byte) error {
+	r := paxi.NewBinaryReader(data)
+	m.Ballot = r.Ballot()
+	m.Slot = int(r.Int())
+	m.Command = r.Command()
+	return r.Err()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler for binary codec
+func (m P2b) MarshalBinary() ([]byte, error) {
+	w := new(paxi.BinaryWriter)
+	w.PutBallot(m.Ballot)
+	w.PutString(string(m.ID))
+	w.PutInt(int64(m.Slot))
+	return w.Data(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for binary codec
+func (m *P2b) UnmarshalBinary(data []byte) error {
+	r := paxi.NewBinaryReader(data)
+	m.Ballot = r.Ballot()
+	m.ID = paxi.ID(r.String())
+	m.Slot = int(r.Int())
+	return r.Err()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler for binary codec
+func (m P3) MarshalBinary() ([]byte, error) {
+	w := new(paxi.BinaryWriter)
+	w.PutBallot(m.Ballot)
+	w.PutInt(int64(m.Slot))
+	w.PutCommand(m.Command)
+	return w.Data(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for binary codec
+func (m *P3) UnmarshalBinary(data []byte) error {
+	r := paxi.NewBinaryReader(data)
+	m.Ballot = r.Ballot()
+	m.Slot = int(r.Int())
+	m.Command = r.Command()
+	return r.Err()
+}
@@ -19,13 +19,20 @@ func NewClient(id paxi.ID) *Client {
 	}
 }
 
+// NewSession returns client sharing connections of c in a new session
+func (c *Client) NewSession() *Client {
+	return &Client{
+		HTTPClient: c.HTTPClient.NewSession(),
+		ballot:     c.ballot,
+	}
+}
+
 // Get implements paxi.Client interface
 // there are three reading modes:
 // (1) read as normal command
 // (2) read from leader with current ballot number
 // (3) read from quorum of replicas with barrier
 func (c *Client) Get(key paxi.Key) (paxi.Value, error) {
-	c.HTTPClient.CID++
 	switch *read {
 	case "leader":
 		return c.readLeader(key)
@@ -39,7 +46,6 @@ func (c *Client) Get(key paxi.Key) (paxi.Value, error) {
 }
 
 func (c *Client) Put(key paxi.Key, value paxi.Value) error {
-	c.HTTPClient.CID++
 	_, meta, err := c.RESTPut(c.ID, key, value)
 	if err == nil {
 		b := paxi.NewBallotFromString(meta[HTTPHeaderBallot])
@@ -0,0 +1,83 @@
+package paxos
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// tick is the periodic timer message of failure detector
+type tick struct{}
+
+// detector is the failure detector of leader, active leader sends heartbeats on every tick,
+// and follower that has not heard from leader within randomized election timeout starts phase 1
+type detector struct {
+	interval time.Duration // heartbeat interval, disabled if 0
+	election time.Duration // minimum election timeout
+	timeout  time.Duration // election timeout of current term
+	random   *rand.Rand
+}
+
+func newDetector(id paxi.ID, now time.Time) detector {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	d := detector{
+		interval: time.Duration(paxi.GetConfig().Heartbeat) * time.Millisecond,
+		election: time.Duration(paxi.GetConfig().Election) * time.Millisecond,
+		random:   rand.New(rand.NewSource(int64(h.Sum64()) ^ now.UnixNano())),
+	}
+	if d.election <= 0 {
+		d.election = 10 * d.interval
+	}
+	return d
+}
+
+func (d *detector) enabled() bool {
+	return d.interval > 0
+}
+
+// restart randomizes election timeout in [election, 2 * election)
+func (d *detector) restart() {
+	d.timeout = d.election + time.Duration(d.random.Int63n(int64(d.election)))
+}
+
+// startDetector starts ticking of failure detector, leader is trusted for one election timeout
+func (r *Replica) startDetector() {
+	if !r.detector.enabled() {
+		return
+	}
+	r.Paxos.heard = r.Clock().Now()
+	r.detector.restart()
+	r.Every(r.detector.interval, tick{})
+}
+
+func (r *Replica) handleTick(tick) {
+	if r.Paxos.Active() {
+		r.Broadcast(Heartbeat{Ballot: r.Paxos.Ballot()})
+		return
+	}
+	if r.Clock().Now().Sub(r.Paxos.heard) < r.detector.timeout {
+		return
+	}
+	log.Infof("replica %s suspects leader %s, starts election", r.ID(), r.Paxos.Leader())
+	r.Paxos.heard = r.Clock().Now()
+	r.detector.restart()
+	r.Paxos.P1a()
+}
+
+// HandleHeartbeat handles Heartbeat message from leader
+func (p *Paxos) HandleHeartbeat(m Heartbeat) {
+	if m.Ballot < p.ballot {
+		return
+	}
+	if m.Ballot > p.ballot {
+		p.ballot = m.Ballot
+		p.active = false
+		p.persist(record{Type: ballotRecord, Ballot: p.ballot}, true)
+		p.forward()
+	}
+	p.heard = p.Clock().Now()
+}
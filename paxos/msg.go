@@ -13,6 +13,15 @@ func init() {
 	gob.Register(P2a{})
 	gob.Register(P2b{})
 	gob.Register(P3{})
+	gob.Register(CatchUp{})
+	gob.Register(InstallSnapshot{})
+	gob.Register(Heartbeat{})
+
+	// hot messages with hand-written binary encoding
+	paxi.RegisterMessage(10, P1a{})
+	paxi.RegisterMessage(11, P2a{})
+	paxi.RegisterMessage(12, P2b{})
+	paxi.RegisterMessage(13, P3{})
 }
 
 // P1a prepare message
@@ -77,3 +86,35 @@ type P3 struct {
 func (m P3) String() string {
 	return fmt.Sprintf("P3 {b=%v s=%d cmd=%v}", m.Ballot, m.Slot, m.Command)
 }
+
+// Heartbeat message is broadcast periodically by active leader
+type Heartbeat struct {
+	Ballot paxi.Ballot
+}
+
+func (m Heartbeat) String() string {
+	return fmt.Sprintf("Heartbeat {b=%v}", m.Ballot)
+}
+
+// CatchUp message is sent by a lagging replica to request missing state starting from slot
+type CatchUp struct {
+	ID   paxi.ID // from node id
+	Slot int     // next slot to execute in requesting replica
+}
+
+func (m CatchUp) String() string {
+	return fmt.Sprintf("CatchUp {id=%s s=%d}", m.ID, m.Slot)
+}
+
+// InstallSnapshot replies CatchUp with state snapshot and committed log suffix
+type InstallSnapshot struct {
+	Ballot   paxi.Ballot
+	ID       paxi.ID               // from node id
+	Slot     int                   // first slot not included in snapshot
+	Snapshot []byte                // state machine snapshot, nil if not needed
+	Log      map[int]CommandBallot // committed commands from slot
+}
+
+func (m InstallSnapshot) String() string {
+	return fmt.Sprintf("InstallSnapshot {b=%v id=%s s=%d size=%d log=%d}", m.Ballot, m.ID, m.Slot, len(m.Snapshot), len(m.Log))
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
 )
 
 // entry in log
@@ -17,6 +18,15 @@ type entry struct {
 	timestamp time.Time
 }
 
+// snapshot of state machine that covers all slots before slot
+type snapshot struct {
+	slot   int
+	ballot paxi.Ballot
+	data   []byte
+}
+
+var errSnapshotInstalled = paxi.Error("slot is covered by installed snapshot")
+
 // Paxos instance
 type Paxos struct {
 	paxi.Node
@@ -32,9 +42,17 @@ type Paxos struct {
 	quorum   *paxi.Quorum    // phase 1 quorum
 	requests []*paxi.Request // phase 1 pending requests
 
-	Q1              func(*paxi.Quorum) bool
-	Q2              func(*paxi.Quorum) bool
-	ReplyWhenCommit bool
+	wal *wal // write-ahead log of acceptor state, nil if disabled
+
+	snapshot snapshot  // latest state snapshot
+	catchup  time.Time // last time catch up was requested
+	heard    time.Time // last time leader of current ballot was heard
+
+	Q1               func(*paxi.Quorum) bool
+	Q2               func(*paxi.Quorum) bool
+	ReplyWhenCommit  bool
+	SnapshotInterval int                     // number of executed slots between snapshots, disabled if 0
+	Ready            func(paxi.Command) bool // execution of committed command waits until ready, always ready if nil
 }
 
 // NewPaxos creates new paxos instance
@@ -57,6 +75,171 @@ func NewPaxos(n paxi.Node, options ...func(*Paxos)) *Paxos {
 	return p
 }
 
+// OpenWAL enables the write-ahead log in path and recovers ballot, log and executed slots from existing records
+func (p *Paxos) OpenWAL(path string) error {
+	w, records, err := openWAL(path)
+	if err != nil {
+		return err
+	}
+	p.wal = w
+
+	// durable state machine keeps executed entries, others are rebuilt from snapshot and committed records
+	applied := 0
+	if d := p.Durable(); d != nil {
+		applied = d.Applied()
+	} else if len(records) > 0 {
+		if err := p.Restore(nil); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range records {
+		if r.Ballot > p.ballot {
+			p.ballot = r.Ballot
+		}
+		if r.Type != snapshotRecord && r.Type != ballotRecord && r.Slot < p.execute {
+			continue
+		}
+		switch r.Type {
+		case snapshotRecord:
+			if r.Slot > applied {
+				if err := p.restore(r.Data, r.Slot); err != nil {
+					return err
+				}
+			}
+			p.snapshot = snapshot{slot: r.Slot, ballot: r.Ballot, data: r.Data}
+			p.execute = r.Slot
+			p.slot = paxi.Max(p.slot, r.Slot-1)
+			for s := range p.log {
+				if s < r.Slot {
+					delete(p.log, s)
+				}
+			}
+		case acceptRecord:
+			p.slot = paxi.Max(p.slot, r.Slot)
+			e, exists := p.log[r.Slot]
+			if !exists {
+				p.log[r.Slot] = &entry{
+					ballot:  r.Ballot,
+					command: r.Command,
+				}
+			} else if !e.commit && r.Ballot >= e.ballot {
+				e.ballot = r.Ballot
+				e.command = r.Command
+			}
+		case commitRecord:
+			p.slot = paxi.Max(p.slot, r.Slot)
+			e, exists := p.log[r.Slot]
+			if !exists {
+				e = &entry{ballot: r.Ballot}
+				p.log[r.Slot] = e
+			}
+			e.command = r.Command
+			e.commit = true
+		}
+	}
+	if applied > p.execute {
+		for s := range p.log {
+			if s < applied {
+				delete(p.log, s)
+			}
+		}
+		p.execute = applied
+		p.slot = paxi.Max(p.slot, applied-1)
+	}
+	log.Infof("replica %s recovered %d wal records, ballot %v slot %d", p.ID(), len(records), p.ballot, p.slot)
+
+	p.exec()
+	return nil
+}
+
+// Reset clears volatile state as after a crash, and recovers from write-ahead log if enabled
+func (p *Paxos) Reset() error {
+	p.log = make(map[int]*entry, paxi.GetConfig().BufferSize)
+	p.execute = 0
+	p.active = false
+	p.ballot = 0
+	p.slot = -1
+	p.quorum = paxi.NewQuorum()
+	p.requests = make([]*paxi.Request, 0)
+	p.snapshot = snapshot{}
+	p.catchup = time.Time{}
+	p.heard = time.Time{}
+	if p.wal == nil {
+		if d := p.Durable(); d != nil {
+			p.execute = d.Applied()
+			p.slot = p.execute - 1
+		}
+		return nil
+	}
+	path := p.wal.path
+	p.wal.close()
+	p.wal = nil
+	return p.OpenWAL(path)
+}
+
+// restore restores state machine with snapshot of log entries before slot
+func (p *Paxos) restore(snapshot []byte, slot int) error {
+	if d := p.Durable(); d != nil {
+		d.SetApplied(slot)
+	}
+	return p.Restore(snapshot)
+}
+
+// persist appends record to write-ahead log if enabled
+// sync is required before any promise or accepted message is sent
+func (p *Paxos) persist(r record, sync bool) {
+	if p.wal == nil {
+		return
+	}
+	err := p.wal.append(r, sync)
+	if err != nil {
+		log.Fatalf("replica %s cannot write wal: %v", p.ID(), err)
+	}
+}
+
+// compact replaces the write-ahead log with latest snapshot and log entries after it
+func (p *Paxos) compact() {
+	if p.wal == nil {
+		return
+	}
+	records := []record{
+		{Type: ballotRecord, Ballot: p.ballot},
+		{Type: snapshotRecord, Ballot: p.snapshot.ballot, Slot: p.snapshot.slot, Data: p.snapshot.data},
+	}
+	for s := p.snapshot.slot; s <= p.slot; s++ {
+		e, exists := p.log[s]
+		if !exists {
+			continue
+		}
+		t := acceptRecord
+		if e.commit {
+			t = commitRecord
+		}
+		records = append(records, record{Type: t, Ballot: e.ballot, Slot: s, Command: e.command})
+	}
+	err := p.wal.compact(records)
+	if err != nil {
+		log.Fatalf("replica %s cannot compact wal: %v", p.ID(), err)
+	}
+}
+
+// takeSnapshot snapshots the state machine at execute slot and truncates the log before it
+func (p *Paxos) takeSnapshot() {
+	p.snapshot = snapshot{
+		slot:   p.execute,
+		ballot: p.ballot,
+		data:   p.Snapshot(),
+	}
+	for s := range p.log {
+		if s < p.execute {
+			delete(p.log, s)
+		}
+	}
+	p.compact()
+	log.Debugf("replica %s takes snapshot at slot %d", p.ID(), p.execute)
+}
+
 // IsLeader indecates if this node is current leader
 func (p *Paxos) IsLeader() bool {
 	return p.active || p.ballot.ID() == p.ID()
@@ -72,6 +255,11 @@ func (p *Paxos) Ballot() paxi.Ballot {
 	return p.ballot
 }
 
+// Active indicates if this node finished phase 1 as leader of current ballot
+func (p *Paxos) Active() bool {
+	return p.active
+}
+
 // SetActive sets current paxos instance as active leader
 func (p *Paxos) SetActive(active bool) {
 	p.active = active
@@ -102,6 +290,7 @@ func (p *Paxos) P1a() {
 		return
 	}
 	p.ballot.Next(p.ID())
+	p.persist(record{Type: ballotRecord, Ballot: p.ballot}, true)
 	p.quorum.Reset()
 	p.quorum.ACK(p.ID())
 	p.Broadcast(P1a{Ballot: p.ballot})
@@ -115,9 +304,10 @@ func (p *Paxos) P2a(r *paxi.Request) {
 		command:   r.Command,
 		request:   r,
 		quorum:    paxi.NewQuorum(),
-		timestamp: time.Now(),
+		timestamp: p.Clock().Now(),
 	}
 	p.log[p.slot].quorum.ACK(p.ID())
+	p.persist(record{Type: acceptRecord, Ballot: p.ballot, Slot: p.slot, Command: r.Command}, true)
 	m := P2a{
 		Ballot:  p.ballot,
 		Slot:    p.slot,
@@ -138,6 +328,8 @@ func (p *Paxos) HandleP1a(m P1a) {
 	if m.Ballot > p.ballot {
 		p.ballot = m.Ballot
 		p.active = false
+		p.heard = p.Clock().Now()
+		p.persist(record{Type: ballotRecord, Ballot: p.ballot}, true)
 		// TODO use BackOff time or forward
 		// forward pending requests to new leader
 		p.forward()
@@ -193,6 +385,7 @@ func (p *Paxos) HandleP1b(m P1b) {
 	if m.Ballot > p.ballot {
 		p.ballot = m.Ballot
 		p.active = false // not necessary
+		p.persist(record{Type: ballotRecord, Ballot: p.ballot}, true)
 		// forward pending requests to new leader
 		p.forward()
 		// p.P1a()
@@ -212,6 +405,7 @@ func (p *Paxos) HandleP1b(m P1b) {
 				p.log[i].ballot = p.ballot
 				p.log[i].quorum = paxi.NewQuorum()
 				p.log[i].quorum.ACK(p.ID())
+				p.persist(record{Type: acceptRecord, Ballot: p.ballot, Slot: i, Command: p.log[i].command}, true)
 				p.Broadcast(P2a{
 					Ballot:  p.ballot,
 					Slot:    i,
@@ -232,8 +426,12 @@ func (p *Paxos) HandleP2a(m P2a) {
 	// log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Ballot.ID(), m, p.ID())
 
 	if m.Ballot >= p.ballot {
+		if m.Ballot > p.ballot {
+			p.persist(record{Type: ballotRecord, Ballot: m.Ballot}, true)
+		}
 		p.ballot = m.Ballot
 		p.active = false
+		p.heard = p.Clock().Now()
 		// update slot number
 		p.slot = paxi.Max(p.slot, m.Slot)
 		// update entry
@@ -255,6 +453,9 @@ func (p *Paxos) HandleP2a(m P2a) {
 				commit:  false,
 			}
 		}
+		if !p.log[m.Slot].commit {
+			p.persist(record{Type: acceptRecord, Ballot: m.Ballot, Slot: m.Slot, Command: m.Command}, true)
+		}
 	}
 
 	p.Send(m.Ballot.ID(), P2b{
@@ -279,6 +480,7 @@ func (p *Paxos) HandleP2b(m P2b) {
 	if m.Ballot > p.ballot {
 		p.ballot = m.Ballot
 		p.active = false
+		p.persist(record{Type: ballotRecord, Ballot: p.ballot}, true)
 	}
 
 	// ack message
@@ -288,6 +490,7 @@ func (p *Paxos) HandleP2b(m P2b) {
 		p.log[m.Slot].quorum.ACK(m.ID)
 		if p.Q2(p.log[m.Slot].quorum) {
 			p.log[m.Slot].commit = true
+			p.persist(record{Type: commitRecord, Ballot: m.Ballot, Slot: m.Slot, Command: p.log[m.Slot].command}, false)
 			p.Broadcast(P3{
 				Ballot:  m.Ballot,
 				Slot:    m.Slot,
@@ -312,6 +515,9 @@ func (p *Paxos) HandleP3(m P3) {
 	// log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Ballot.ID(), m, p.ID())
 
 	p.slot = paxi.Max(p.slot, m.Slot)
+	if m.Ballot == p.ballot {
+		p.heard = p.Clock().Now()
+	}
 
 	e, exist := p.log[m.Slot]
 	if exist {
@@ -327,6 +533,16 @@ func (p *Paxos) HandleP3(m P3) {
 
 	e.command = m.Command
 	e.commit = true
+	p.persist(record{Type: commitRecord, Ballot: m.Ballot, Slot: m.Slot, Command: m.Command}, false)
+
+	// missed commits before this slot, ask leader for snapshot and missing entries
+	if p.gap(m.Slot) && p.Clock().Now().Sub(p.catchup) > time.Second {
+		p.catchup = p.Clock().Now()
+		p.Send(m.Ballot.ID(), CatchUp{
+			ID:   p.ID(),
+			Slot: p.execute,
+		})
+	}
 
 	if p.ReplyWhenCommit {
 		if e.request != nil {
@@ -340,19 +556,41 @@ func (p *Paxos) HandleP3(m P3) {
 	}
 }
 
+// gap returns true if any slot before given slot is not committed and cannot be executed
+func (p *Paxos) gap(slot int) bool {
+	for s := p.execute; s < slot; s++ {
+		if e, exists := p.log[s]; !exists || !e.commit {
+			return true
+		}
+	}
+	return false
+}
+
+// Exec executes committed log entries in order until next entry is not ready
+func (p *Paxos) Exec() {
+	p.exec()
+}
+
 func (p *Paxos) exec() {
 	for {
 		e, ok := p.log[p.execute]
 		if !ok || !e.commit {
 			break
 		}
+		if p.Ready != nil && !p.Ready(e.command) {
+			break
+		}
 		// log.Debugf("Replica %s execute [s=%d, cmd=%v]", p.ID(), p.execute, e.command)
-		value := p.Execute(e.command)
+		if d := p.Durable(); d != nil {
+			d.SetApplied(p.execute + 1)
+		}
+		value, err := p.Execute(e.command)
 		if e.request != nil {
 			reply := paxi.Reply{
 				Command:    e.command,
 				Value:      value,
 				Properties: make(map[string]string),
+				Err:        err,
 			}
 			reply.Properties[HTTPHeaderSlot] = strconv.Itoa(p.execute)
 			reply.Properties[HTTPHeaderBallot] = e.ballot.String()
@@ -360,10 +598,104 @@ func (p *Paxos) exec() {
 			e.request.Reply(reply)
 			e.request = nil
 		}
-		// TODO clean up the log periodically
-		delete(p.log, p.execute)
+		// executed entries are kept until next snapshot to help lagging replicas catch up
+		if p.SnapshotInterval <= 0 {
+			delete(p.log, p.execute)
+		}
 		p.execute++
 	}
+	if p.SnapshotInterval > 0 && p.execute-p.snapshot.slot >= p.SnapshotInterval {
+		p.takeSnapshot()
+	}
+}
+
+// HandleCatchUp replies lagging replica with latest snapshot and committed entries after it
+func (p *Paxos) HandleCatchUp(m CatchUp) {
+	if m.Slot >= p.execute {
+		return
+	}
+	reply := InstallSnapshot{
+		Ballot: p.ballot,
+		ID:     p.ID(),
+		Slot:   m.Slot,
+		Log:    make(map[int]CommandBallot),
+	}
+	if m.Slot < p.snapshot.slot {
+		reply.Slot = p.snapshot.slot
+		reply.Snapshot = p.snapshot.data
+	}
+	// executed entries are not kept without periodic snapshots, send current state instead
+	if _, exists := p.log[reply.Slot]; !exists && reply.Slot < p.execute {
+		reply.Slot = p.execute
+		reply.Snapshot = p.Snapshot()
+	}
+	for s := reply.Slot; s <= p.slot; s++ {
+		e, exists := p.log[s]
+		if !exists || !e.commit {
+			continue
+		}
+		reply.Log[s] = CommandBallot{e.command, e.ballot}
+	}
+	p.Send(m.ID, reply)
+}
+
+// HandleInstallSnapshot installs snapshot and committed entries from InstallSnapshot message
+func (p *Paxos) HandleInstallSnapshot(m InstallSnapshot) {
+	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, p.ID())
+	installed := false
+	if m.Snapshot != nil && m.Slot > p.execute {
+		err := p.restore(m.Snapshot, m.Slot)
+		if err != nil {
+			log.Errorf("replica %s cannot restore snapshot: %v", p.ID(), err)
+			return
+		}
+		for s, e := range p.log {
+			if s >= m.Slot {
+				continue
+			}
+			if e.request != nil {
+				e.request.Reply(paxi.Reply{
+					Command: e.request.Command,
+					Err:     errSnapshotInstalled,
+				})
+			}
+			delete(p.log, s)
+		}
+		p.snapshot = snapshot{slot: m.Slot, ballot: m.Ballot, data: m.Snapshot}
+		p.execute = m.Slot
+		p.slot = paxi.Max(p.slot, m.Slot-1)
+		installed = true
+	}
+
+	for s, cb := range m.Log {
+		if s < p.execute {
+			continue
+		}
+		p.slot = paxi.Max(p.slot, s)
+		e, exists := p.log[s]
+		if !exists {
+			e = &entry{}
+			p.log[s] = e
+		}
+		if e.commit {
+			continue
+		}
+		if !e.command.Equal(cb.Command) && e.request != nil {
+			p.Forward(m.Ballot.ID(), *e.request)
+			e.request = nil
+		}
+		e.ballot = cb.Ballot
+		e.command = cb.Command
+		e.commit = true
+		if !installed {
+			p.persist(record{Type: commitRecord, Ballot: cb.Ballot, Slot: s, Command: cb.Command}, false)
+		}
+	}
+
+	if installed {
+		p.compact()
+	}
+	p.exec()
 }
 
 func (p *Paxos) forward() {
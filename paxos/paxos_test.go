@@ -1,7 +1,12 @@
 package paxos
 
 import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/ailidani/paxi"
 )
@@ -9,3 +14,153 @@ import (
 func TestPaxos(t *testing.T) {
 	paxi.Simulation()
 }
+
+func TestBinaryMessages(t *testing.T) {
+	b := paxi.NewBallot(2, "1.3")
+	cmd := paxi.Command{Key: "k", Value: paxi.Value("v"), ClientID: "1.1", CommandID: 3}
+	data, _ := P2a{Ballot: b, Slot: 42, Command: cmd}.MarshalBinary()
+	var m P2a
+	if err := m.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if m.Ballot != b || m.Slot != 42 || !m.Command.Equal(cmd) {
+		t.Errorf("unexpected decoded message %v", m)
+	}
+
+	data, _ = P2b{Ballot: b, ID: "2.1", Slot: 7}.MarshalBinary()
+	var r P2b
+	if err := r.UnmarshalBinary(data); err != nil || r.ID != "2.1" || r.Slot != 7 {
+		t.Errorf("unexpected decoded message %v %v", r, err)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paxos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(`{
+		"address": {"1.1": "tcp://127.0.0.1:1781", "1.2": "tcp://127.0.0.1:1782", "1.3": "tcp://127.0.0.1:1783"},
+		"http_address": {"1.1": "http://127.0.0.1:8281", "1.2": "http://127.0.0.1:8282", "1.3": "http://127.0.0.1:8283"},
+		"heartbeat": 100,
+		"election": 1000
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag.Set("config", path)
+	flag.Set("log_dir", dir)
+	paxi.Init()
+
+	sim := paxi.Simulate(1)
+	replicas := make(map[paxi.ID]*Replica)
+	for _, id := range []paxi.ID{"1.1", "1.2", "1.3"} {
+		replicas[id] = NewReplica(id)
+	}
+	// leader returns active leader except crashed one
+	leader := func(crashed paxi.ID) paxi.ID {
+		for id, r := range replicas {
+			if id != crashed && r.Paxos.Active() {
+				return id
+			}
+		}
+		return ""
+	}
+	var elapsed time.Duration
+	run := func(d time.Duration) {
+		elapsed += d
+		sim.Run(elapsed)
+	}
+	put := func(id paxi.ID, v string) (paxi.Reply, bool) {
+		var reply paxi.Reply
+		replied := false
+		sim.Submit(id, paxi.Command{Key: "k", Value: paxi.Value(v)}, func(r paxi.Reply) {
+			reply, replied = r, true
+		})
+		run(time.Second)
+		return reply, replied
+	}
+
+	run(3 * time.Second)
+	old := leader("")
+	if old == "" {
+		t.Fatal("expected leader elected without requests")
+	}
+	var follower paxi.ID
+	for id := range replicas {
+		if id != old {
+			follower = id
+		}
+	}
+	if r, ok := put(follower, "v1"); !ok || r.Err != nil {
+		t.Fatalf("expected request redirected to leader %s and replied, got %v %v", old, ok, r.Err)
+	}
+
+	replicas[old].Node.Crash(0)
+	run(5 * time.Second)
+	if l := leader(old); l == "" {
+		t.Fatalf("expected new leader after %s crashed, got %q", old, l)
+	}
+	if r, ok := put(follower, "v2"); !ok || r.Err != nil {
+		t.Fatalf("expected request served by new leader, got %v %v", ok, r.Err)
+	}
+	for id, r := range replicas {
+		if v, _ := r.Node.Execute(paxi.Command{Key: "k"}); id != old && string(v) != "v2" {
+			t.Errorf("expected replica %s executed requests", id)
+		}
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paxos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(`{
+		"address": {"1.1": "tcp://127.0.0.1:1781", "1.2": "tcp://127.0.0.1:1782", "1.3": "tcp://127.0.0.1:1783"},
+		"http_address": {"1.1": "http://127.0.0.1:8281", "1.2": "http://127.0.0.1:8282", "1.3": "http://127.0.0.1:8283"},
+		"heartbeat": 0,
+		"snapshot": 0
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag.Set("config", path)
+	flag.Set("log_dir", dir)
+	paxi.Init()
+
+	sim := paxi.Simulate(1)
+	replicas := make(map[paxi.ID]*Replica)
+	for _, id := range []paxi.ID{"1.1", "1.2", "1.3"} {
+		replicas[id] = NewReplica(id)
+	}
+	// lagging replica misses every accept and commit while drop is set
+	drop := true
+	replicas["1.3"].Node.Intercept(func(m interface{}, next paxi.Handler) {
+		switch m.(type) {
+		case P2a, P3:
+			if drop {
+				return
+			}
+		}
+		next(m)
+	})
+	var elapsed time.Duration
+	put := func(v string) {
+		sim.Submit("1.1", paxi.Command{Key: "k", Value: paxi.Value(v)}, func(paxi.Reply) {})
+		elapsed += time.Second
+		sim.Run(elapsed)
+	}
+
+	put("v1")
+	put("v2")
+	drop = false
+	put("v3")
+	if v, _ := replicas["1.3"].Node.Execute(paxi.Command{Key: "k"}); string(v) != "v3" {
+		t.Errorf("expected lagging replica to catch up with v3, got %q", v)
+	}
+}
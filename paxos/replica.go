@@ -2,8 +2,8 @@ package paxos
 
 import (
 	"flag"
+	"path/filepath"
 	"strconv"
-	"time"
 
 	"github.com/ailidani/paxi"
 	"github.com/ailidani/paxi/log"
@@ -23,6 +23,7 @@ const (
 type Replica struct {
 	paxi.Node
 	*Paxos
+	detector detector
 }
 
 // NewReplica generates new Paxos replica
@@ -30,15 +31,37 @@ func NewReplica(id paxi.ID) *Replica {
 	r := new(Replica)
 	r.Node = paxi.NewNode(id)
 	r.Paxos = NewPaxos(r)
+	r.Paxos.SnapshotInterval = paxi.GetConfig().Snapshot
+	if dir := paxi.GetConfig().WAL; dir != "" {
+		err := r.Paxos.OpenWAL(filepath.Join(dir, string(id)+".wal"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 	r.Register(paxi.Request{}, r.handleRequest)
 	r.Register(P1a{}, r.HandleP1a)
 	r.Register(P1b{}, r.HandleP1b)
 	r.Register(P2a{}, r.HandleP2a)
 	r.Register(P2b{}, r.HandleP2b)
 	r.Register(P3{}, r.HandleP3)
+	r.Register(CatchUp{}, r.HandleCatchUp)
+	r.Register(InstallSnapshot{}, r.HandleInstallSnapshot)
+	r.Register(Heartbeat{}, r.HandleHeartbeat)
+	r.Register(tick{}, r.handleTick)
+	r.Register(paxi.Recover{}, r.handleRecover)
+	r.detector = newDetector(id, r.Clock().Now())
+	r.startDetector()
 	return r
 }
 
+// handleRecover restarts replica after crash from write-ahead log, or with empty state without log
+func (r *Replica) handleRecover(paxi.Recover) {
+	if err := r.Paxos.Reset(); err != nil {
+		log.Fatalf("replica %s cannot recover: %v", r.ID(), err)
+	}
+	r.startDetector()
+}
+
 func (r *Replica) handleRequest(m paxi.Request) {
 	log.Debugf("Replica %s received %v\n", r.ID(), m)
 
@@ -48,7 +71,7 @@ func (r *Replica) handleRequest(m paxi.Request) {
 			Command:    m.Command,
 			Value:      v,
 			Properties: make(map[string]string),
-			Timestamp:  time.Now().Unix(),
+			Timestamp:  r.Clock().Now().Unix(),
 		}
 		reply.Properties[HTTPHeaderSlot] = strconv.Itoa(r.Paxos.slot)
 		reply.Properties[HTTPHeaderBallot] = r.Paxos.ballot.String()
@@ -60,11 +83,25 @@ func (r *Replica) handleRequest(m paxi.Request) {
 
 	if *ephemeralLeader || r.Paxos.IsLeader() || r.Paxos.Ballot() == 0 {
 		r.Paxos.HandleRequest(m)
+	} else if r.detector.enabled() {
+		r.redirect(m)
+	} else if _, sim := r.Clock().(*paxi.Simulator); sim {
+		// simulation runs every event in order of its scheduler
+		r.Forward(r.Paxos.Leader(), m)
 	} else {
 		go r.Forward(r.Paxos.Leader(), m)
 	}
 }
 
+// redirect replies client to resend request to current leader
+func (r *Replica) redirect(m paxi.Request) {
+	m.Reply(paxi.Reply{
+		Command:    m.Command,
+		Properties: map[string]string{paxi.HTTPLeader: string(r.Paxos.Leader())},
+		Err:        paxi.ErrRedirect,
+	})
+}
+
 func (r *Replica) readInProgress(m paxi.Request) (paxi.Value, bool) {
 	// TODO
 	// (1) last slot is read?
@@ -74,11 +111,12 @@ func (r *Replica) readInProgress(m paxi.Request) (paxi.Value, bool) {
 	// is in progress
 	for i := r.Paxos.slot; i >= r.Paxos.execute; i-- {
 		entry, exist := r.Paxos.log[i]
-		if exist && entry.command.Key == m.Command.Key {
+		if exist && entry.command.Key == m.Command.Key && entry.command.Operation() == paxi.OpPut {
 			return entry.command.Value, true
 		}
 	}
 
 	// not in progress key
-	return r.Node.Execute(m.Command), false
+	v, _ := r.Node.Execute(m.Command)
+	return v, false
 }
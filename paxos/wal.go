@@ -0,0 +1,163 @@
+package paxos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// types of record in write-ahead log
+const (
+	ballotRecord   byte = iota // promised ballot
+	acceptRecord               // accepted command in slot
+	commitRecord               // committed command in slot
+	snapshotRecord             // state snapshot up to slot
+)
+
+// record is one entry of the write-ahead log
+type record struct {
+	Type    byte
+	Ballot  paxi.Ballot
+	Slot    int
+	Command paxi.Command
+	Data    []byte // snapshot data
+}
+
+var errCorrupt = errors.New("corrupted wal record")
+
+// wal is an append-only file of acceptor state
+// every record is framed as [length uint32][crc32 uint32][gob payload]
+type wal struct {
+	path string
+	file *os.File
+	buf  bytes.Buffer
+}
+
+// openWAL opens or creates the log file in path and returns all valid records in it
+// a torn or corrupted tail left by a crash is truncated
+func openWAL(path string) (*wal, []record, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records := make([]record, 0)
+	var offset int64
+	for {
+		r, n, err := readRecord(file)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Warningf("wal %s truncated at offset %d: %v", path, offset, err)
+			break
+		}
+		records = append(records, r)
+		offset += n
+	}
+
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return &wal{path: path, file: file}, records, nil
+}
+
+func readRecord(r io.Reader) (record, int64, error) {
+	var rec record
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return rec, 0, errCorrupt
+		}
+		return rec, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	sum := binary.BigEndian.Uint32(header[4:])
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return rec, 0, errCorrupt
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return rec, 0, errCorrupt
+	}
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&rec); err != nil {
+		return rec, 0, err
+	}
+	return rec, int64(len(header) + len(payload)), nil
+}
+
+// frame encodes the record with length and checksum header
+func (w *wal) frame(r record) ([]byte, error) {
+	w.buf.Reset()
+	if err := gob.NewEncoder(&w.buf).Encode(r); err != nil {
+		return nil, err
+	}
+	payload := w.buf.Bytes()
+	frame := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[8:], payload)
+	return frame, nil
+}
+
+// append writes the record to the end of log, fsync before return if sync is true
+func (w *wal) append(r record, sync bool) error {
+	frame, err := w.frame(r)
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Write(frame); err != nil {
+		return err
+	}
+	if sync {
+		return w.file.Sync()
+	}
+	return nil
+}
+
+// compact atomically replaces the entire log with given records
+func (w *wal) compact(records []record) error {
+	tmp := w.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		frame, err := w.frame(r)
+		if err == nil {
+			_, err = file.Write(frame)
+		}
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		file.Close()
+		return err
+	}
+	w.file.Close()
+	w.file = file
+	return nil
+}
+
+func (w *wal) close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,97 @@
+package paxos
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/ailidani/paxi"
+)
+
+func TestWAL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "1.1.wal")
+
+	w, records, err := openWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("expected empty wal, got %d records", len(records))
+	}
+
+	b := paxi.NewBallot(1, "1.1")
+	cmd := paxi.Command{Key: "1", Value: paxi.Value("v")}
+	w.append(record{Type: ballotRecord, Ballot: b}, true)
+	w.append(record{Type: acceptRecord, Ballot: b, Slot: 0, Command: cmd}, true)
+	w.append(record{Type: commitRecord, Ballot: b, Slot: 0, Command: cmd}, false)
+	w.close()
+
+	// simulate torn write at the end of file
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{0, 0, 0, 42, 1})
+	f.Close()
+
+	w, records, err = openWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	if records[1].Type != acceptRecord || records[1].Ballot != b || !records[1].Command.Equal(cmd) {
+		t.Errorf("unexpected record %+v", records[1])
+	}
+
+	// new records are appended after truncated tail
+	w.append(record{Type: ballotRecord, Ballot: paxi.NewBallot(2, "1.2")}, true)
+	_, records, _ = openWAL(path)
+	if len(records) != 4 {
+		t.Errorf("expected 4 records, got %d", len(records))
+	}
+}
+
+func TestWALCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "1.1.wal")
+
+	w, _, err := openWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := paxi.NewBallot(1, "1.1")
+	for i := 0; i < 10; i++ {
+		w.append(record{Type: commitRecord, Ballot: b, Slot: i, Command: paxi.Command{Key: paxi.Key(strconv.Itoa(i))}}, false)
+	}
+	err = w.compact([]record{
+		{Type: ballotRecord, Ballot: b},
+		{Type: snapshotRecord, Ballot: b, Slot: 10, Data: []byte("state")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.append(record{Type: acceptRecord, Ballot: b, Slot: 10}, true)
+	w.close()
+
+	_, records, err := openWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	if records[1].Type != snapshotRecord || records[1].Slot != 10 || string(records[1].Data) != "state" {
+		t.Errorf("unexpected snapshot record %+v", records[1])
+	}
+}
@@ -2,6 +2,8 @@ package paxos_group
 
 import (
 	"flag"
+	"hash/fnv"
+	"strconv"
 
 	"github.com/ailidani/paxi"
 	"github.com/ailidani/paxi/log"
@@ -29,7 +31,12 @@ func NewReplica(id paxi.ID) *Replica {
 
 // static paxos groups
 func index(key paxi.Key) int {
-	return int(key) % *groups
+	if k, err := strconv.Atoi(string(key)); err == nil && k >= 0 {
+		return k % *groups
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(*groups))
 }
 
 func (r *Replica) paxos(gid int) *paxos.Paxos {
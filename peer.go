@@ -0,0 +1,145 @@
+package paxi
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// policies of full outbound queue
+const (
+	QueueBlock = "block" // sender waits for free space in queue
+	QueueDrop  = "drop"  // message is dropped and counted
+)
+
+// reconnect backoff bounds of peer connection
+const (
+	minBackoff = 50 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+// PeerState is the state of outbound connection to peer
+type PeerState struct {
+	Connected bool      `json:"connected"`
+	Since     time.Time `json:"since"`           // time of last state change
+	Retries   int       `json:"retries"`         // failed attempts since last connected
+	Queued    int       `json:"queued"`          // messages waiting in outbound queue
+	Dropped   int64     `json:"dropped"`         // messages dropped by full queue or encoding error
+	Error     string    `json:"error,omitempty"` // last connection error
+
+	Datagrams *DatagramStats `json:"datagrams,omitempty"` // datagram counters of udp transport
+}
+
+// peer keeps state of outbound connection
+type peer struct {
+	sync.RWMutex
+	state PeerState
+}
+
+func (p *peer) connected() {
+	p.Lock()
+	defer p.Unlock()
+	p.state.Connected = true
+	p.state.Since = time.Now()
+	p.state.Retries = 0
+	p.state.Error = ""
+}
+
+func (p *peer) disconnected(err error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.state.Connected || p.state.Since.IsZero() {
+		p.state.Since = time.Now()
+	}
+	p.state.Connected = false
+	p.state.Retries++
+	if err != nil {
+		p.state.Error = err.Error()
+	}
+}
+
+func (p *peer) dropped() {
+	p.Lock()
+	p.state.Dropped++
+	p.Unlock()
+}
+
+// backoff returns exponential delay of retry with jitter
+func backoff(retries int) time.Duration {
+	d := minBackoff
+	for i := 1; i < retries && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// connect keeps connection to remote server for the lifetime of transport,
+// it reconnects with backoff when dial or write fails, and writes messages from send queue in order
+// message that failed to write is resent on the new connection
+func (t *transport) connect(dial func() (net.Conn, error)) {
+	var m interface{}
+	var msgs []interface{} // messages of pending batch
+	pending := false
+	for {
+		conn, err := dial()
+		if err != nil {
+			t.peer.disconnected(err)
+			retries := t.State().Retries
+			log.Debugf("dial %s failed %d times: %v", t.uri, retries, err)
+			select {
+			case <-t.close:
+				return
+			case <-time.After(backoff(retries)):
+			}
+			continue
+		}
+		t.peer.connected()
+		log.Debugf("connected to %s", t.uri)
+
+		codec := newCodec(conn)
+		var batches *batcher
+		if config.Batching {
+			batches = newBatcher()
+			if pending && msgs != nil {
+				// batch of lost connection is encoded again for the stream of new connection
+				m = batches.encode(msgs)
+			}
+		}
+		for err == nil {
+			if !pending {
+				select {
+				case m = <-t.send:
+				case <-t.close:
+					t.flush(func(m interface{}) error {
+						return codec.Encode(&m)
+					})
+					conn.Close()
+					return
+				}
+				msgs = nil
+				if batches != nil {
+					m, msgs = t.batch(m, batches)
+				}
+				pending = true
+			}
+			err = codec.Encode(&m)
+			if err == nil {
+				pending = false
+			} else if _, ok := err.(net.Error); !ok {
+				// message cannot be encoded, the stream is reset without it
+				log.Errorf("drop message %+v to %s: %v", m, t.uri, err)
+				t.peer.dropped()
+				pending = false
+			}
+		}
+		conn.Close()
+		t.peer.disconnected(err)
+		log.Warningf("connection to %s lost: %v", t.uri, err)
+	}
+}
@@ -242,12 +242,13 @@ func (r *Replica) exec() {
 			break
 		}
 
-		value := r.Node.Execute(e.command)
+		value, err := r.Node.Execute(e.command)
 		if e.request != nil {
 			reply := paxi.Reply{
 				Command:    e.command,
 				Value:      value,
 				Properties: make(map[string]string),
+				Err:        err,
 			}
 			e.request.Reply(reply)
 			e.request = nil
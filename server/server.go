@@ -2,7 +2,8 @@ package main
 
 import (
 	"flag"
-	"sync"
+	"sort"
+	"time"
 
 	"github.com/ailidani/paxi"
 	"github.com/ailidani/paxi/abd"
@@ -25,6 +26,7 @@ import (
 var algorithm = flag.String("algorithm", "paxos", "Distributed algorithm")
 var id = flag.String("id", "", "ID in format of Zone.Node.")
 var simulation = flag.Bool("sim", false, "simulation mode")
+var seed = flag.Int64("seed", 0, "seed of deterministic simulation, random if 0")
 
 var master = flag.String("master", "", "Master address.")
 
@@ -88,14 +90,21 @@ func main() {
 	paxi.Init()
 
 	if *simulation {
-		var wg sync.WaitGroup
-		wg.Add(1)
-		paxi.Simulation()
-		for id := range paxi.GetConfig().Addrs {
-			n := id
-			go replica(n)
+		if *seed == 0 {
+			*seed = time.Now().UnixNano()
+		}
+		sim := paxi.Simulate(*seed)
+		ids := paxi.GetConfig().IDs()
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			replica(id)
+		}
+		history := sim.Benchmark()
+		if paxi.GetConfig().Benchmark.LinearizabilityCheck {
+			if n := history.Linearizable(); n > 0 {
+				log.Fatalf("simulation seed %d found %d anomalies", *seed, n)
+			}
 		}
-		wg.Wait()
 	} else {
 		replica(paxi.ID(*id))
 	}
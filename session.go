@@ -0,0 +1,151 @@
+package paxi
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// ErrStaleCommand is returned to command of client older than every command kept in its session
+var ErrStaleCommand = Error("stale command")
+
+// outcome is the result of an executed command
+type outcome struct {
+	Command Command
+	Value   Value
+	Err     string
+}
+
+func (o outcome) err() error {
+	if o.Err == "" {
+		return nil
+	}
+	return Error(o.Err)
+}
+
+// sessionID identifies one session of client
+type sessionID struct {
+	Client  ID
+	Session int64
+}
+
+// session keeps results of the latest commands of one client session
+type session struct {
+	Outcomes map[int]outcome // by command id
+	Low      int             // largest command id no longer kept
+	Seen     int64           // logical time of latest command
+}
+
+// sessions is the replicated table of client sessions, every write command with client and command id
+// is executed at most once and its retry gets the cached result,
+// sessions idle for session ttl in logical time of command timestamps expire the same on every replica,
+// sessions are kept in memory and in snapshots only, durable state machine restarted without snapshot forgets them
+type sessions struct {
+	sync.Mutex
+	table map[sessionID]*session
+	clock int64 // largest timestamp of executed commands
+	sweep int64 // logical time of last expiration
+}
+
+// sessionState is the snapshot of node state machine with its client sessions
+type sessionState struct {
+	Machine  []byte
+	Sessions map[sessionID]*session
+	Clock    int64
+}
+
+// same checks if command a is a retry of command b
+func same(a, b Command) bool {
+	return a.Key == b.Key && a.Operation() == b.Operation() && bytes.Equal(a.Value, b.Value) &&
+		bytes.Equal(a.Expect, b.Expect) && a.TTL == b.TTL
+}
+
+// execute executes command by f unless it was executed before
+func (s *sessions) execute(c Command, f func(Command) (Value, error)) (Value, error) {
+	if config.SessionTTL <= 0 || c.ClientID == "" || c.CommandID <= 0 || c.IsRead() {
+		return f(c)
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.expire(c.Timestamp)
+	if s.table == nil {
+		s.table = make(map[sessionID]*session)
+	}
+	id := sessionID{c.ClientID, c.Session}
+	ss, exists := s.table[id]
+	if !exists {
+		ss = &session{Outcomes: make(map[int]outcome)}
+		s.table[id] = ss
+	}
+	ss.Seen = s.clock
+	if o, exists := ss.Outcomes[c.CommandID]; exists && same(c, o.Command) {
+		return o.Value, o.err()
+	}
+	if c.CommandID <= ss.Low {
+		return nil, ErrStaleCommand
+	}
+
+	v, err := f(c)
+	o := outcome{Command: c, Value: v}
+	if err != nil {
+		o.Err = err.Error()
+	}
+	ss.Outcomes[c.CommandID] = o
+	if len(ss.Outcomes) > config.SessionWindow {
+		oldest := c.CommandID
+		for id := range ss.Outcomes {
+			if id < oldest {
+				oldest = id
+			}
+		}
+		delete(ss.Outcomes, oldest)
+		ss.Low = Max(ss.Low, oldest)
+	}
+	return v, err
+}
+
+// expire advances logical clock to t and removes idle sessions
+func (s *sessions) expire(t int64) {
+	if t > s.clock {
+		s.clock = t
+	}
+	ttl := int64(time.Duration(config.SessionTTL) * time.Second)
+	if s.clock-s.sweep < ttl/2 {
+		return
+	}
+	s.sweep = s.clock
+	for id, ss := range s.table {
+		if s.clock-ss.Seen > ttl {
+			delete(s.table, id)
+		}
+	}
+}
+
+// Snapshot overrides StateMachine interface to include client sessions
+func (n *node) Snapshot() []byte {
+	n.sessions.Lock()
+	defer n.sessions.Unlock()
+	return encodeState(sessionState{
+		Machine:  n.StateMachine.Snapshot(),
+		Sessions: n.sessions.table,
+		Clock:    n.sessions.clock,
+	})
+}
+
+// Restore overrides StateMachine interface to restore client sessions,
+// snapshot of state machine without sessions is restored with empty sessions
+func (n *node) Restore(snapshot []byte) error {
+	var state sessionState
+	if err := decodeState(snapshot, &state); err != nil {
+		state = sessionState{Machine: snapshot}
+	}
+	n.sessions.Lock()
+	defer n.sessions.Unlock()
+	if err := n.StateMachine.Restore(state.Machine); err != nil {
+		return err
+	}
+	n.sessions.table = state.Sessions
+	n.sessions.clock = state.Clock
+	n.sessions.sweep = state.Clock
+	return nil
+}
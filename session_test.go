@@ -0,0 +1,93 @@
+package paxi
+
+import (
+	"testing"
+	"time"
+)
+
+func add(n *node, cid int, t time.Duration, payload string) string {
+	v, err := n.Execute(Command{Key: "a", Value: Value(payload), ClientID: "1.1", CommandID: cid, Timestamp: int64(t)})
+	if err != nil {
+		return err.Error()
+	}
+	return string(v)
+}
+
+func TestSession(t *testing.T) {
+	n := &node{StateMachine: NewCounter()}
+	if v := add(n, 1, 0, "add 2"); v != "2" {
+		t.Fatalf("expected counter 2, got %s", v)
+	}
+	if v := add(n, 1, time.Second, "add 2"); v != "2" {
+		t.Errorf("expected cached result of retry 2, got %s", v)
+	}
+	if v := add(n, 2, time.Second, "add 3"); v != "5" {
+		t.Errorf("expected counter 5, got %s", v)
+	}
+
+	// sessions are part of snapshot
+	snapshot := n.Snapshot()
+	n.Restore(nil)
+	if v := string(get(n, "a")); v != "0" {
+		t.Errorf("expected reset counter 0, got %s", v)
+	}
+	if err := n.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if v := add(n, 2, time.Second, "add 3"); v != "5" {
+		t.Errorf("expected restored cached result 5, got %s", v)
+	}
+
+	// oldest results are dropped beyond window
+	for cid := 3; cid < 3+config.SessionWindow; cid++ {
+		add(n, cid, time.Second, "add 0")
+	}
+	if v := add(n, 1, time.Second, "add 2"); v != ErrStaleCommand.Error() {
+		t.Errorf("expected stale command, got %s", v)
+	}
+
+	// idle session expires in command time
+	ttl := time.Duration(config.SessionTTL) * time.Second
+	n.Execute(Command{Key: "b", Value: Value("add 1"), ClientID: "1.2", CommandID: 1, Timestamp: int64(2 * ttl)})
+	if v := add(n, 2, 2*ttl, "add 3"); v != "8" {
+		t.Errorf("expected expired session to execute again, got %s", v)
+	}
+
+	// snapshot of state machine without sessions
+	c := NewCounter()
+	c.Execute(Command{Key: "a", Value: Value("add 1")})
+	if err := n.Restore(c.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	if v := add(n, 3, 2*ttl, "add 2"); v != "3" {
+		t.Errorf("expected counter 3, got %s", v)
+	}
+}
+
+func TestSessionApart(t *testing.T) {
+	n := &node{StateMachine: NewCounter()}
+	c := Command{Key: "a", Value: Value("add 1"), ClientID: "1.1", CommandID: 1}
+	n.Execute(c)
+	c.Session = 1
+	if v, _ := n.Execute(c); string(v) != "2" {
+		t.Errorf("expected command of other session executed, got %s", v)
+	}
+
+	// concurrent clients of one session get distinct command ids
+	client := NewHTTPClient("1.1")
+	session := client.NewSession()
+	if session.Session == client.Session {
+		t.Errorf("expected new session, got %d", session.Session)
+	}
+	ids := make(chan int, 100)
+	for i := 0; i < 100; i++ {
+		go func() { ids <- client.next() }()
+	}
+	seen := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		seen[<-ids] = true
+	}
+	if len(seen) != 100 {
+		t.Errorf("expected 100 distinct command ids, got %d", len(seen))
+	}
+}
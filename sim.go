@@ -0,0 +1,399 @@
+package paxi
+
+import (
+	"container/heap"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// simulator is the active simulation, nodes created when it is set run in simulation
+var simulator *Simulator
+
+// epoch is the start of virtual time
+var epoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Simulator runs all nodes in one process and one goroutine on a virtual clock
+// every message delivery and timer is an event in the order chosen by a seeded random source,
+// so a run is replayed exactly from its seed as long as handlers do not start goroutines
+type Simulator struct {
+	sync.Mutex
+	Seed     int64
+	MinDelay time.Duration // minimum delay of message delivery
+	MaxDelay time.Duration // maximum delay of message delivery
+
+	rand    *rand.Rand
+	now     time.Duration // virtual time since epoch
+	seq     int64         // order of events scheduled at the same time
+	events  events
+	nodes   map[ID]*node
+	ids     []ID                    // nodes in order of id
+	hosts   map[string]ID           // node id of address host
+	links   map[[2]ID]time.Duration // last delivery time of link keeps messages in order
+	replies []reply                 // reply channels waiting in simulation
+	trace   hash.Hash64
+}
+
+// event is function scheduled at virtual time
+type event struct {
+	at      time.Duration
+	seq     int64
+	f       func()
+	stopped bool
+}
+
+// events is min heap of events ordered by time and sequence
+type events []*event
+
+func (e events) Len() int { return len(e) }
+func (e events) Less(i, j int) bool {
+	return e[i].at < e[j].at || (e[i].at == e[j].at && e[i].seq < e[j].seq)
+}
+func (e events) Swap(i, j int)       { e[i], e[j] = e[j], e[i] }
+func (e *events) Push(x interface{}) { *e = append(*e, x.(*event)) }
+func (e *events) Pop() interface{} {
+	old := *e
+	x := old[len(old)-1]
+	*e = old[:len(old)-1]
+	return x
+}
+
+// reply is a reply channel with callback
+type reply struct {
+	c chan Reply
+	f func(Reply)
+}
+
+// Simulate starts deterministic simulation of given seed for all nodes created afterwards
+func Simulate(seed int64) *Simulator {
+	s := &Simulator{
+		Seed:     seed,
+		MinDelay: time.Millisecond,
+		MaxDelay: 10 * time.Millisecond,
+		rand:     rand.New(rand.NewSource(seed)),
+		nodes:    make(map[ID]*node),
+		hosts:    make(map[string]ID),
+		links:    make(map[[2]ID]time.Duration),
+		trace:    fnv.New64a(),
+	}
+	for id, addr := range config.Addrs {
+		s.hosts[host(addr)] = id
+	}
+	simulator = s
+	log.Infof("simulation seed %d", seed)
+	return s
+}
+
+// host returns host and port of node address
+func host(addr string) string {
+	if !strings.Contains(addr, "://") {
+		addr = *scheme + "://" + addr
+	}
+	uri, err := url.Parse(addr)
+	if err != nil {
+		log.Fatalf("error parsing address %s : %s\n", addr, err)
+	}
+	return uri.Host
+}
+
+// add adds node to simulation
+func (s *Simulator) add(n *node) {
+	s.Lock()
+	defer s.Unlock()
+	s.nodes[n.id] = n
+	s.ids = append(s.ids, n.id)
+	sort.Slice(s.ids, func(i, j int) bool { return s.ids[i] < s.ids[j] })
+}
+
+// Now returns current virtual time
+func (s *Simulator) Now() time.Time {
+	s.Lock()
+	defer s.Unlock()
+	return epoch.Add(s.now)
+}
+
+// AfterFunc schedules f to run in simulation after d of virtual time
+func (s *Simulator) AfterFunc(d time.Duration, f func()) Timer {
+	s.Lock()
+	defer s.Unlock()
+	return &simTimer{s, s.schedule(s.now+d, f)}
+}
+
+type simTimer struct {
+	s *Simulator
+	e *event
+}
+
+func (t *simTimer) Stop() bool {
+	t.s.Lock()
+	defer t.s.Unlock()
+	if t.e.stopped || t.e.f == nil {
+		return false
+	}
+	t.e.stopped = true
+	return true
+}
+
+// schedule pushes f at virtual time, caller must hold lock
+func (s *Simulator) schedule(at time.Duration, f func()) *event {
+	s.seq++
+	e := &event{at: at, seq: s.seq, f: f}
+	heap.Push(&s.events, e)
+	return e
+}
+
+// perm returns seeded random permutation of n
+func (s *Simulator) perm(n int) []int {
+	s.Lock()
+	defer s.Unlock()
+	return s.rand.Perm(n)
+}
+
+// float64 returns seeded random number in [0.0,1.0)
+func (s *Simulator) float64() float64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.rand.Float64()
+}
+
+// send schedules delivery of message to node with address host after random delay,
+// messages of the same link are delivered in order
+func (s *Simulator) send(from ID, host string, m interface{}) {
+	s.Lock()
+	defer s.Unlock()
+	to, exists := s.hosts[host]
+	if !exists {
+		log.Errorf("simulation does not have node of address %s", host)
+		return
+	}
+	at := s.now + s.MinDelay
+	if s.MaxDelay > s.MinDelay {
+		at += time.Duration(s.rand.Int63n(int64(s.MaxDelay - s.MinDelay + 1)))
+	}
+	link := [2]ID{from, to}
+	if at < s.links[link] {
+		at = s.links[link]
+	}
+	s.links[link] = at
+	s.schedule(at, func() {
+		s.deliver(from, to, m)
+	})
+}
+
+// deliver passes message to node handler as node.recv does
+func (s *Simulator) deliver(from, to ID, m interface{}) {
+	fmt.Fprintf(s.trace, "%d %s %s %T\n", s.now, from, to, m)
+	n, exists := s.nodes[to]
+	if !exists || n.crashed() {
+		return
+	}
+	switch r := m.(type) {
+	case Request:
+		r.c = make(chan Reply, 1)
+		s.await(r.c, func(reply Reply) {
+			n.Send(r.NodeID, reply)
+		})
+		m = r
+	case Reply:
+		n.reply(r)
+		return
+	}
+	n.call(m)
+}
+
+// await calls f with reply in simulation once it is sent to channel c
+func (s *Simulator) await(c chan Reply, f func(Reply)) {
+	s.Lock()
+	defer s.Unlock()
+	s.replies = append(s.replies, reply{c, f})
+}
+
+// Submit schedules client request of command to node id, f is called with the reply in simulation,
+// redirected request is submitted again to leader
+func (s *Simulator) Submit(id ID, cmd Command, f func(Reply)) {
+	s.Lock()
+	defer s.Unlock()
+	s.schedule(s.now, func() {
+		fmt.Fprintf(s.trace, "%d client %s %v\n", s.now, id, cmd)
+		n, exists := s.nodes[id]
+		if !exists {
+			f(Reply{Command: cmd, Err: fmt.Errorf("node %s not in simulation", id)})
+			return
+		}
+		if n.crashed() {
+			f(Reply{Command: cmd, Err: ErrCrashed})
+			return
+		}
+		if cmd.Timestamp == 0 {
+			cmd.Timestamp = epoch.Add(s.now).UnixNano()
+		}
+		r := Request{
+			Command:   cmd,
+			Timestamp: cmd.Timestamp,
+			c:         make(chan Reply, 1),
+		}
+		s.await(r.c, func(reply Reply) {
+			// client follows redirect to leader
+			if leader := ID(reply.Properties[HTTPLeader]); reply.Err == ErrRedirect && leader != id {
+				s.Submit(leader, cmd, f)
+				return
+			}
+			f(reply)
+		})
+		n.call(r)
+	})
+}
+
+// Step runs the next event and everything it triggers, returns false if no event is left
+func (s *Simulator) Step() bool {
+	s.Lock()
+	if len(s.events) == 0 {
+		s.Unlock()
+		return false
+	}
+	e := heap.Pop(&s.events).(*event)
+	s.now = e.at
+	f := e.f
+	e.f = nil
+	if e.stopped {
+		f = nil
+	}
+	s.Unlock()
+
+	if f != nil {
+		f()
+	}
+	s.drain()
+	return true
+}
+
+// drain runs messages that handlers put into message channel of nodes and callbacks of replies,
+// in order of node id and reply registration
+func (s *Simulator) drain() {
+	for progress := true; progress; {
+		progress = false
+		s.Lock()
+		replies := s.replies
+		s.replies = nil
+		s.Unlock()
+		for i, r := range replies {
+			select {
+			case reply := <-r.c:
+				r.f(reply)
+				progress = true
+			default:
+				s.await(replies[i].c, replies[i].f)
+			}
+		}
+		for _, id := range s.ids {
+			n := s.nodes[id]
+			for len(n.MessageChan) > 0 {
+				m := <-n.MessageChan
+				if !n.crashed() {
+					n.call(m)
+				}
+				progress = true
+			}
+		}
+	}
+}
+
+// Run runs events until d of virtual time elapsed since simulation started or no event is left
+func (s *Simulator) Run(d time.Duration) {
+	for {
+		s.Lock()
+		next := len(s.events) > 0 && s.events[0].at <= d
+		if !next && s.now < d {
+			s.now = d
+		}
+		s.Unlock()
+		if !next {
+			return
+		}
+		s.Step()
+	}
+}
+
+// Trace returns hash of all events happened in simulation,
+// runs of the same seed and workload have the same trace
+func (s *Simulator) Trace() uint64 {
+	return s.trace.Sum64()
+}
+
+// Benchmark runs closed-loop clients of benchmark configuration against simulated nodes
+// for T seconds of virtual time or until N operations completed, and returns operation history
+func (s *Simulator) Benchmark() *History {
+	b := config.Benchmark
+	history := NewHistory()
+	done := 0
+	var client func(id int)
+	client = func(id int) {
+		if b.N > 0 && done >= b.N {
+			return
+		}
+		s.Lock()
+		node := s.ids[s.rand.Intn(len(s.ids))]
+		k := Key(strconv.Itoa(b.Min + s.rand.Intn(b.K)))
+		write := s.rand.Float64() < b.W
+		v := s.rand.Int()
+		start := s.now
+		s.Unlock()
+
+		cmd := Command{Key: k, ClientID: ID(fmt.Sprintf("0.%d", id)), CommandID: done}
+		if write {
+			cmd.Value = Value(strconv.Itoa(v))
+		}
+		s.Submit(node, cmd, func(r Reply) {
+			done++
+			op := &operation{start: int64(start), end: int64(s.now)}
+			if write {
+				op.input = v
+			} else {
+				op.output, _ = strconv.Atoi(string(r.Value))
+			}
+			if r.Err != nil {
+				op.end = math.MaxInt64
+			}
+			history.AddOperation(k, op)
+			client(id)
+		})
+	}
+	for i := 1; i <= b.Concurrency; i++ {
+		client(i)
+	}
+	s.Run(time.Duration(b.T) * time.Second)
+	log.Infof("simulation seed %d completed %d operations in %v with trace %x", s.Seed, done, s.now, s.Trace())
+	return history
+}
+
+// simTransport sends messages through simulator regardless of address scheme
+type simTransport struct {
+	*transport
+	sim *Simulator
+}
+
+func (t *simTransport) Scheme() string {
+	return "sim"
+}
+
+func (t *simTransport) Send(m interface{}) {
+	t.sim.send(t.id, t.uri.Host, m)
+}
+
+func (t *simTransport) Dial() error {
+	t.peer.connected()
+	return nil
+}
+
+// Listen does nothing, simulator delivers messages to node directly
+func (t *simTransport) Listen() {}
@@ -0,0 +1,81 @@
+package paxi
+
+import (
+	"testing"
+	"time"
+)
+
+type ping struct {
+	From ID
+	N    int
+}
+
+// simulate runs a gossip of pings between three nodes and returns the order of received pings and trace
+func simulate(seed int64) ([]ping, uint64) {
+	c := config
+	defer func() {
+		config = c
+		simulator = nil
+	}()
+	config.Addrs = map[ID]string{"1.1": "tcp://127.0.0.1:1801", "1.2": "tcp://127.0.0.1:1802", "1.3": "tcp://127.0.0.1:1803"}
+
+	sim := Simulate(seed)
+	received := make([]ping, 0)
+	for _, id := range []ID{"1.1", "1.2", "1.3"} {
+		n := NewNode(id)
+		n.Register(Request{}, func(r Request) {
+			n.Broadcast(ping{From: n.ID(), N: 0})
+			r.Reply(Reply{Command: r.Command})
+		})
+		n.Register(ping{}, func(p ping) {
+			received = append(received, p)
+			if p.N < 3 {
+				n.Broadcast(ping{From: n.ID(), N: p.N + 1})
+			}
+		})
+		n.Run()
+	}
+	replied := false
+	sim.Submit("1.1", Command{Key: "k"}, func(Reply) { replied = true })
+	sim.Run(time.Second)
+	if !replied {
+		return nil, 0
+	}
+	return received, sim.Trace()
+}
+
+func TestSimulationReplay(t *testing.T) {
+	a, traceA := simulate(42)
+	b, traceB := simulate(42)
+	if len(a) == 0 || len(a) != len(b) || traceA != traceB {
+		t.Fatalf("expected same run of the same seed, got %d/%d pings, trace %x/%x", len(a), len(b), traceA, traceB)
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("ping %d differs between runs: %v %v", i, a[i], b[i])
+		}
+	}
+	if _, traceC := simulate(43); traceC == traceA {
+		t.Errorf("expected different run of another seed")
+	}
+}
+
+func TestSimulationClock(t *testing.T) {
+	defer func() { simulator = nil }()
+	sim := Simulate(1)
+	start := sim.Now()
+	fired := time.Duration(0)
+	sim.AfterFunc(3*time.Second, func() { fired = sim.Now().Sub(start) })
+	stopped := sim.AfterFunc(time.Second, func() { t.Error("stopped timer fired") })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("expected timer stopped once")
+	}
+	sim.Run(2 * time.Second)
+	if fired != 0 || sim.Now().Sub(start) != 2*time.Second {
+		t.Errorf("unexpected virtual time %v before timer", sim.Now().Sub(start))
+	}
+	sim.Run(5 * time.Second)
+	if fired != 3*time.Second {
+		t.Errorf("expected timer fired at 3s of virtual time, got %v", fired)
+	}
+}
@@ -2,6 +2,7 @@ package paxi
 
 import (
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
@@ -23,29 +24,43 @@ type Socket interface {
 	// Broadcast send to all peers
 	Broadcast(m interface{})
 
-	// Recv receives a message
+	// Recv receives a message, returns nil after socket is closed
 	Recv() interface{}
 
+	// Peers returns outbound connection state of every dialed peer,
+	// and inbound datagram counters of udp listener under own id
+	Peers() map[ID]PeerState
+
+	// Close closes all connections and listener of node
 	Close()
 
-	// Fault injection
-	Drop(id ID, t int)             // drops every message send to ID last for t seconds
-	Slow(id ID, d int, t int)      // delays every message send to ID for d ms and last for t seconds
-	Flaky(id ID, p float64, t int) // drop message by chance p for t seconds
-	Crash(t int)                   // node crash for t seconds
+	// Fault injection, t <= 0 lasts until healed
+	Drop(id ID, t int)                 // drops every message send to ID last for t seconds
+	Slow(id ID, d int, t int)          // delays every message send to ID for d ms and last for t seconds
+	Flaky(id ID, p float64, t int)     // drop message by chance p for t seconds
+	Duplicate(id ID, p float64, t int) // send message to ID twice by chance p for t seconds
+	Reorder(id ID, p float64, t int)   // hold back message to ID by chance p so later messages overtake it, for t seconds
+	Partition(ids []ID, t int)         // drops every message send to any of ids as one fault for t seconds
+	Crash(t int)                       // node crash for t seconds
+	Faults() []Fault                   // active faults
+	Heal()                             // removes all faults
+	HealFault(f Fault)                 // removes the fault of type, peer and id of f
 }
 
 type socket struct {
 	id        ID
 	addresses map[ID]string
+	ids       []ID // peers in order of id
 	nodes     map[ID]Transport
+	perm      func(int) []int // random permutation of peers
+	random    func() float64  // random chance of faults
+	clock     Clock
 
-	crash bool
-	drop  map[ID]bool
-	slow  map[ID]int
-	flaky map[ID]float64
+	faults map[faultKey]Fault
+	seq    int  // sequence of injected faults
+	closed bool // socket is closed
 
-	lock sync.RWMutex // locking map nodes
+	lock sync.RWMutex // locking map nodes and faults
 }
 
 // NewSocket return Socket interface instance given self ID, node list, transport and codec name
@@ -54,13 +69,24 @@ func NewSocket(id ID, addrs map[ID]string) Socket {
 		id:        id,
 		addresses: addrs,
 		nodes:     make(map[ID]Transport),
-		crash:     false,
-		drop:      make(map[ID]bool),
-		slow:      make(map[ID]int),
-		flaky:     make(map[ID]float64),
+		perm:      rand.Perm,
+		random:    rand.Float64,
+		clock:     wallClock{},
+		faults:    make(map[faultKey]Fault),
+	}
+	for peer := range addrs {
+		if peer != id {
+			socket.ids = append(socket.ids, peer)
+		}
+	}
+	sort.Slice(socket.ids, func(i, j int) bool { return socket.ids[i] < socket.ids[j] })
+	if simulator != nil {
+		socket.perm = simulator.perm
+		socket.random = simulator.float64
+		socket.clock = simulator
 	}
 
-	socket.nodes[id] = NewTransport(addrs[id])
+	socket.nodes[id] = newTransport(addrs[id], id)
 	socket.nodes[id].Listen()
 
 	return socket
@@ -69,23 +95,26 @@ func NewSocket(id ID, addrs map[ID]string) Socket {
 func (s *socket) Send(to ID, m interface{}) {
 	log.Debugf("node %s send message %+v to %v", s.id, m, to)
 
-	if s.crash {
-		return
-	}
+	s.lock.RLock()
+	_, crash := s.faults[faultKey{FaultCrash, "", 0}]
+	_, drop := s.faults[faultKey{FaultDrop, to, 0}]
+	drop = drop || s.partitioned(to)
+	slow := s.faults[faultKey{FaultSlow, to, 0}].Delay
+	flaky := s.faults[faultKey{FaultFlaky, to, 0}].P
+	duplicate := s.faults[faultKey{FaultDuplicate, to, 0}].P
+	reorder := s.faults[faultKey{FaultReorder, to, 0}].P
+	t, exists := s.nodes[to]
+	closed := s.closed
+	s.lock.RUnlock()
 
-	if s.drop[to] {
+	if crash || drop || closed {
 		return
 	}
 
-	if p, ok := s.flaky[to]; ok && p > 0 {
-		if rand.Float64() < p {
-			return
-		}
+	if flaky > 0 && s.random() < flaky {
+		return
 	}
 
-	s.lock.RLock()
-	t, exists := s.nodes[to]
-	s.lock.RUnlock()
 	if !exists {
 		s.lock.RLock()
 		address, ok := s.addresses[to]
@@ -94,26 +123,45 @@ func (s *socket) Send(to ID, m interface{}) {
 			log.Errorf("socket does not have address of node %s", to)
 			return
 		}
-		t = NewTransport(address)
-		err := Retry(t.Dial, 100, time.Duration(50)*time.Millisecond)
+		// transport connects in background with backoff, messages are queued meanwhile
+		t = newTransport(address, s.id)
+		err := t.Dial()
 		if err != nil {
-			panic(err)
+			log.Errorf("node %s cannot dial %s: %v", s.id, to, err)
+			return
 		}
 		s.lock.Lock()
-		s.nodes[to] = t
+		if s.closed {
+			s.lock.Unlock()
+			t.Close()
+			return
+		}
+		if existing, exists := s.nodes[to]; exists {
+			t.Close()
+			t = existing
+		} else {
+			s.nodes[to] = t
+		}
 		s.lock.Unlock()
 	}
 
-	if delay, ok := s.slow[to]; ok && delay > 0 {
-		timer := time.NewTimer(time.Duration(delay) * time.Millisecond)
-		go func() {
-			<-timer.C
-			t.Send(m)
-		}()
-		return
+	copies := 1
+	if duplicate > 0 && s.random() < duplicate {
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		delay := time.Duration(slow) * time.Millisecond
+		if reorder > 0 && s.random() < reorder {
+			delay += time.Duration(s.random() * float64(reorderDelay))
+		}
+		if delay > 0 {
+			s.clock.AfterFunc(delay, func() {
+				t.Send(m)
+			})
+			continue
+		}
+		t.Send(m)
 	}
-
-	t.Send(m)
 }
 
 func (s *socket) Recv() interface{} {
@@ -122,18 +170,36 @@ func (s *socket) Recv() interface{} {
 	s.lock.RUnlock()
 	for {
 		m := t.Recv()
-		if !s.crash {
+		if m == nil {
+			return nil
+		}
+		s.lock.RLock()
+		_, crash := s.faults[faultKey{FaultCrash, "", 0}]
+		s.lock.RUnlock()
+		if !crash {
 			return m
 		}
 	}
 }
 
+func (s *socket) Peers() map[ID]PeerState {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	peers := make(map[ID]PeerState)
+	for id, t := range s.nodes {
+		if id != s.id {
+			peers[id] = t.State()
+		} else if state := t.State(); state.Datagrams != nil {
+			// inbound datagram counters of udp listener
+			peers[id] = PeerState{Datagrams: state.Datagrams}
+		}
+	}
+	return peers
+}
+
 func (s *socket) MulticastZone(zone int, m interface{}) {
 	//log.Debugf("node %s broadcasting message %+v in zone %d", s.id, m, zone)
-	for id := range s.addresses {
-		if id == s.id {
-			continue
-		}
+	for _, id := range s.ids {
 		if id.Zone() == zone {
 			s.Send(id, m)
 		}
@@ -143,11 +209,8 @@ func (s *socket) MulticastZone(zone int, m interface{}) {
 func (s *socket) MulticastQuorum(quorum int, m interface{}) {
 	//log.Debugf("node %s multicasting message %+v for %d nodes", s.id, m, quorum)
 	i := 0
-	for id := range s.addresses {
-		if id == s.id {
-			continue
-		}
-		s.Send(id, m)
+	for _, j := range s.perm(len(s.ids)) {
+		s.Send(s.ids[j], m)
 		i++
 		if i == quorum {
 			break
@@ -157,54 +220,16 @@ func (s *socket) MulticastQuorum(quorum int, m interface{}) {
 
 func (s *socket) Broadcast(m interface{}) {
 	//log.Debugf("node %s broadcasting message %+v", s.id, m)
-	for id := range s.addresses {
-		if id == s.id {
-			continue
-		}
+	for _, id := range s.ids {
 		s.Send(id, m)
 	}
 }
 
 func (s *socket) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
 	for _, t := range s.nodes {
 		t.Close()
 	}
 }
-
-func (s *socket) Drop(id ID, t int) {
-	s.drop[id] = true
-	timer := time.NewTimer(time.Duration(t) * time.Second)
-	go func() {
-		<-timer.C
-		s.drop[id] = false
-	}()
-}
-
-func (s *socket) Slow(id ID, delay int, t int) {
-	s.slow[id] = delay
-	timer := time.NewTimer(time.Duration(t) * time.Second)
-	go func() {
-		<-timer.C
-		s.slow[id] = 0
-	}()
-}
-
-func (s *socket) Flaky(id ID, p float64, t int) {
-	s.flaky[id] = p
-	timer := time.NewTimer(time.Duration(t) * time.Second)
-	go func() {
-		<-timer.C
-		s.flaky[id] = 0
-	}()
-}
-
-func (s *socket) Crash(t int) {
-	s.crash = true
-	if t > 0 {
-		timer := time.NewTimer(time.Duration(t) * time.Second)
-		go func() {
-			<-timer.C
-			s.crash = false
-		}()
-	}
-}
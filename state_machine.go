@@ -1,12 +1,61 @@
 package paxi
 
-// StateMachine defines a deterministic state machine
+import (
+	"sync"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// StateMachine defines a deterministic state machine replicated by protocols
+// command value is an opaque payload interpreted by the state machine,
+// and nil value means a read-only command
 type StateMachine interface {
 	// Execute is the state-transition function
-	// returns current state value if state unchanged or previous state value
-	Execute(interface{}) interface{}
+	// returns current state value if state unchanged or previous state value,
+	// and error if the command cannot be applied
+	Execute(Command) (Value, error)
+
+	// Snapshot returns the serialized state
+	Snapshot() []byte
+
+	// Restore replaces the state with snapshot, empty snapshot resets the state
+	Restore([]byte) error
 }
 
 type State interface {
 	Hash() uint64
 }
+
+var machines = struct {
+	sync.RWMutex
+	m map[string]func(ID) StateMachine
+}{m: make(map[string]func(ID) StateMachine)}
+
+func init() {
+	RegisterStateMachine("kv", func(id ID) StateMachine { return NewDatabase(id) })
+	RegisterStateMachine("counter", func(ID) StateMachine { return NewCounter() })
+	RegisterStateMachine("queue", func(ID) StateMachine { return NewQueue() })
+	RegisterStateMachine("lock", func(ID) StateMachine { return NewLockTable() })
+}
+
+// RegisterStateMachine registers state machine constructor by name used in configuration
+func RegisterStateMachine(name string, f func(ID) StateMachine) {
+	machines.Lock()
+	defer machines.Unlock()
+	machines.m[name] = f
+}
+
+// NewStateMachine creates the state machine in configuration for node id
+func NewStateMachine(id ID) StateMachine {
+	name := config.StateMachine
+	if name == "" {
+		name = "kv"
+	}
+	machines.RLock()
+	f, exists := machines.m[name]
+	machines.RUnlock()
+	if !exists {
+		log.Fatalf("unknown state machine %s", name)
+	}
+	return f(id)
+}
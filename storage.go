@@ -0,0 +1,580 @@
+package paxi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// fsync policies of file storage
+const (
+	FsyncAlways = "always" // fsync after every write
+	FsyncSecond = "second" // fsync dirty file once per second
+	FsyncNever  = "never"  // leave flushing to operating system
+)
+
+var errCorruptRecord = errors.New("corrupted storage record")
+
+// fileDatabase is a persistent Database engine as an append-only log of key-value records
+// with in-memory index of record offsets, values are always read from file
+// every record is framed as [length uint32][crc32 uint32][flags byte][key length uint32][json key][value]
+// flags marks deletion of key, change of key deadline with value of 8 bytes deadline,
+// or a batch of records of one executed command with value of 8 bytes log position followed by their frames
+type fileDatabase struct {
+	sync.RWMutex
+	path         string
+	file         *os.File
+	size         int64           // end of file offset
+	index        map[Key]int64   // offset of latest record of each key
+	sorted       sortedKeys      // ordered keys for range scans
+	history      map[Key][]int64 // offsets of all records of each key
+	expires      expiry
+	version      int
+	multiversion bool
+	fsync        string
+	dirty        bool
+	done         chan struct{} // closed when database is closed
+	applied      int           // log position after the last executed command
+	applying     int           // log position recorded with the next executed command, 0 if none
+	batch        *bytes.Buffer // frames of command being executed
+	batchOffset  int64         // file offset of first frame in batch
+}
+
+// NewFileDatabase opens or creates the storage file in path and rebuilds index from existing records
+func NewFileDatabase(path string, fsync string) (Database, error) {
+	d := &fileDatabase{
+		path:         path,
+		multiversion: config.MultiVersion,
+		fsync:        fsync,
+		done:         make(chan struct{}),
+	}
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+	if fsync == FsyncSecond {
+		go d.sync()
+	}
+	return d, nil
+}
+
+// open opens storage file and scans all records, a torn tail is truncated
+func (d *fileDatabase) open() error {
+	file, err := os.OpenFile(d.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	d.file = file
+	d.size = 0
+	d.version = 0
+	d.applied = 0
+	d.index = make(map[Key]int64)
+	d.history = make(map[Key][]int64)
+	d.expires = expiry{}
+
+	reader := bufio.NewReader(file)
+	for {
+		r, n, err := readKV(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Warningf("storage %s truncated at offset %d: %v", d.path, d.size, err)
+			break
+		}
+		if r.batch {
+			d.applied = int(binary.BigEndian.Uint64(r.value[:8]))
+			d.expires.clock = int64(binary.BigEndian.Uint64(r.value[8:16]))
+			offset := d.size + n - int64(len(r.value)) + 16
+			frames := bytes.NewReader(r.value[16:])
+			for {
+				nested, m, err := readKV(frames)
+				if err != nil {
+					break
+				}
+				d.load(nested, offset)
+				offset += m
+			}
+		} else {
+			d.load(r, d.size)
+		}
+		d.size += n
+	}
+
+	d.sorted = make(sortedKeys, 0, len(d.index))
+	for k := range d.index {
+		d.sorted = append(d.sorted, k)
+	}
+	sort.Slice(d.sorted, func(i, j int) bool { return d.sorted[i] < d.sorted[j] })
+
+	if err := file.Truncate(d.size); err != nil {
+		return err
+	}
+	_, err = file.Seek(d.size, io.SeekStart)
+	return err
+}
+
+// load updates index with record at offset
+func (d *fileDatabase) load(r kvRecord, offset int64) {
+	if r.expire {
+		d.expires.set(r.key, int64(binary.BigEndian.Uint64(r.value)))
+	} else if r.tombstone {
+		d.remove(r.key)
+	} else {
+		d.record(r.key, offset)
+	}
+}
+
+// sync flushes dirty file every second until database is closed
+func (d *fileDatabase) sync() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+		d.Lock()
+		if d.dirty {
+			if err := d.file.Sync(); err != nil {
+				log.Error(err)
+			}
+			d.dirty = false
+		}
+		d.Unlock()
+	}
+}
+
+// Close flushes and closes storage file, database cannot be used afterwards
+func (d *fileDatabase) Close() error {
+	d.Lock()
+	defer d.Unlock()
+	select {
+	case <-d.done:
+		return nil
+	default:
+	}
+	close(d.done)
+	if err := d.file.Sync(); err != nil {
+		d.file.Close()
+		return err
+	}
+	return d.file.Close()
+}
+
+// flags of storage record
+const (
+	flagTombstone byte = 1 << iota // key is deleted
+	flagExpire                     // value is new deadline of key
+	flagBatch                      // value is log position, logical clock and records of one command
+)
+
+// batchHeader is the size of batch record before its first nested frame, with empty key, log position and clock
+const batchHeader = 8 + 5 + 2 + 16
+
+// kvRecord is one record of storage file
+type kvRecord struct {
+	key       Key
+	value     Value
+	tombstone bool // key is deleted
+	expire    bool // key deadline changed
+	batch     bool // records of one executed command
+}
+
+func encodeKV(r kvRecord) ([]byte, error) {
+	key, err := json.Marshal(r.key)
+	if err != nil {
+		return nil, err
+	}
+	size := 5 + len(key) + len(r.value)
+	frame := make([]byte, 8+size)
+	binary.BigEndian.PutUint32(frame[:4], uint32(size))
+	if r.tombstone {
+		frame[8] |= flagTombstone
+	}
+	if r.expire {
+		frame[8] |= flagExpire
+	}
+	if r.batch {
+		frame[8] |= flagBatch
+	}
+	binary.BigEndian.PutUint32(frame[9:13], uint32(len(key)))
+	copy(frame[13:], key)
+	copy(frame[13+len(key):], r.value)
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(frame[8:]))
+	return frame, nil
+}
+
+func readKV(reader io.Reader) (kvRecord, int64, error) {
+	var r kvRecord
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return r, 0, errCorruptRecord
+		}
+		return r, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return r, 0, errCorruptRecord
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) || size < 5 {
+		return r, 0, errCorruptRecord
+	}
+	r.tombstone = payload[0]&flagTombstone != 0
+	r.expire = payload[0]&flagExpire != 0
+	r.batch = payload[0]&flagBatch != 0
+	n := binary.BigEndian.Uint32(payload[1:5])
+	if 5+n > size || (r.expire && 5+n+8 != size) || (r.batch && 5+n+16 > size) {
+		return r, 0, errCorruptRecord
+	}
+	if err := json.Unmarshal(payload[5:5+n], &r.key); err != nil {
+		return r, 0, err
+	}
+	r.value = Value(payload[5+n:])
+	return r, int64(len(header) + len(payload)), nil
+}
+
+// record updates index with new record of key at offset
+func (d *fileDatabase) record(k Key, offset int64) {
+	d.index[k] = offset
+	d.version++
+	if d.multiversion {
+		d.history[k] = append(d.history[k], offset)
+	}
+}
+
+// remove updates index with deletion of key, recorded as negative offset in history
+func (d *fileDatabase) remove(k Key) {
+	d.expires.set(k, 0)
+	delete(d.index, k)
+	d.version++
+	if d.multiversion {
+		d.history[k] = append(d.history[k], -1)
+	}
+}
+
+// read reads value of record at offset
+func (d *fileDatabase) read(offset int64) Value {
+	if offset < 0 {
+		return nil
+	}
+	var reader io.Reader = io.NewSectionReader(d.file, offset, d.size-offset)
+	if d.batch != nil && offset >= d.batchOffset {
+		reader = bytes.NewReader(d.batch.Bytes()[offset-d.batchOffset:])
+	}
+	r, _, err := readKV(reader)
+	if err != nil {
+		log.Errorf("storage %s cannot read offset %d: %v", d.path, offset, err)
+		return nil
+	}
+	return r.value
+}
+
+func (d *fileDatabase) get(k Key) Value {
+	offset, exists := d.index[k]
+	if !exists {
+		return nil
+	}
+	return d.read(offset)
+}
+
+func (d *fileDatabase) put(k Key, v Value) {
+	if v == nil {
+		return
+	}
+	if _, exists := d.index[k]; !exists {
+		d.sorted.insert(k)
+	}
+	offset := d.size
+	d.write(kvRecord{key: k, value: v})
+	d.record(k, offset)
+}
+
+func (d *fileDatabase) delete(k Key) {
+	d.write(kvRecord{key: k, tombstone: true})
+	d.remove(k)
+	d.sorted.remove(k)
+}
+
+func (d *fileDatabase) expiry() *expiry {
+	return &d.expires
+}
+
+func (d *fileDatabase) expire(k Key, deadline int64) {
+	d.write(expireRecord(k, deadline))
+	d.expires.set(k, deadline)
+}
+
+func expireRecord(k Key, deadline int64) kvRecord {
+	v := make(Value, 8)
+	binary.BigEndian.PutUint64(v, uint64(deadline))
+	return kvRecord{key: k, value: v, expire: true}
+}
+
+// write appends record to the end of storage file, or to the batch of command being executed
+func (d *fileDatabase) write(r kvRecord) {
+	frame, err := encodeKV(r)
+	if err != nil {
+		log.Fatalf("storage %s cannot encode: %v", d.path, err)
+	}
+	if d.batch != nil {
+		d.batch.Write(frame)
+		d.size += int64(len(frame))
+		return
+	}
+	d.append(frame)
+}
+
+// append writes frame to the end of storage file
+func (d *fileDatabase) append(frame []byte) {
+	_, err := d.file.Write(frame)
+	if err == nil && d.fsync == FsyncAlways {
+		err = d.file.Sync()
+	}
+	if err != nil {
+		log.Fatalf("storage %s cannot write: %v", d.path, err)
+	}
+	d.size += int64(len(frame))
+	d.dirty = true
+}
+
+// Execute executes a command agaist database
+func (d *fileDatabase) Execute(c Command) (Value, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	// writes of command are recorded atomically with its log position and the logical clock it advanced
+	start := d.size
+	clock := d.expires.clock
+	d.batch = new(bytes.Buffer)
+	d.batchOffset = start + batchHeader
+	d.size = d.batchOffset
+	v, err := execute(d, c)
+	frames := d.batch.Bytes()
+	d.batch = nil
+	d.size = start
+	if d.applying > 0 {
+		d.applied = d.applying
+		d.applying = 0
+	}
+	if len(frames) > 0 || d.expires.clock != clock {
+		d.append(batchRecord(d.applied, d.expires.clock, frames))
+	}
+	return v, err
+}
+
+func batchRecord(applied int, clock int64, frames []byte) []byte {
+	v := make(Value, 16+len(frames))
+	binary.BigEndian.PutUint64(v, uint64(applied))
+	binary.BigEndian.PutUint64(v[8:], uint64(clock))
+	copy(v[16:], frames)
+	frame, _ := encodeKV(kvRecord{value: v, batch: true})
+	return frame
+}
+
+// Applied implements Durable interface
+func (d *fileDatabase) Applied() int {
+	d.RLock()
+	defer d.RUnlock()
+	return d.applied
+}
+
+// SetApplied implements Durable interface
+func (d *fileDatabase) SetApplied(applied int) {
+	d.Lock()
+	defer d.Unlock()
+	d.applying = applied
+}
+
+// Get gets the current value of given key
+func (d *fileDatabase) Get(k Key) Value {
+	d.RLock()
+	defer d.RUnlock()
+	if d.expires.expired(k, d.expires.clock) {
+		return nil
+	}
+	return d.get(k)
+}
+
+// TTL returns remaining time to live of given key in logical time, 0 if key never expires
+func (d *fileDatabase) TTL(k Key) time.Duration {
+	d.RLock()
+	defer d.RUnlock()
+	return d.expires.ttl(k)
+}
+
+// Put puts a new value of given key
+func (d *fileDatabase) Put(k Key, v Value) {
+	d.Lock()
+	defer d.Unlock()
+	d.put(k, v)
+}
+
+func (d *fileDatabase) keys(from, to Key, limit int) []Key {
+	return d.sorted.scan(from, to, limit)
+}
+
+// Scan returns key-value pairs in range [from, to) in order with at most limit results
+func (d *fileDatabase) Scan(from, to Key, limit int) []KeyValue {
+	d.RLock()
+	defer d.RUnlock()
+	kvs := make([]KeyValue, 0)
+	for _, k := range d.keys(from, to, 0) {
+		if limit > 0 && len(kvs) >= limit {
+			break
+		}
+		if !d.expires.expired(k, d.expires.clock) {
+			kvs = append(kvs, KeyValue{k, d.get(k)})
+		}
+	}
+	return kvs
+}
+
+// History returns entire value history in order
+func (d *fileDatabase) History(k Key) []Value {
+	d.RLock()
+	defer d.RUnlock()
+	if d.history[k] == nil {
+		return nil
+	}
+	values := make([]Value, 0, len(d.history[k]))
+	for _, offset := range d.history[k] {
+		values = append(values, d.read(offset))
+	}
+	return values
+}
+
+// Snapshot returns the serialized state of database
+func (d *fileDatabase) Snapshot() []byte {
+	d.RLock()
+	defer d.RUnlock()
+	state := dbState{
+		Data:      make(map[Key]Value),
+		Version:   d.version,
+		History:   make(map[Key][]Value),
+		Clock:     d.expires.clock,
+		Deadlines: d.expires.deadlines,
+		Deleted:   make(map[Key][]int),
+	}
+	for k, offset := range d.index {
+		state.Data[k] = d.read(offset)
+	}
+	for k, offsets := range d.history {
+		for i, offset := range offsets {
+			if offset < 0 {
+				state.Deleted[k] = append(state.Deleted[k], i)
+			}
+			state.History[k] = append(state.History[k], d.read(offset))
+		}
+	}
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(state)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// Restore atomically rewrites storage file with the snapshot and log position set by SetApplied,
+// empty snapshot resets the database
+func (d *fileDatabase) Restore(snapshot []byte) error {
+	var state dbState
+	if len(snapshot) > 0 {
+		err := gob.NewDecoder(bytes.NewReader(snapshot)).Decode(&state)
+		if err != nil {
+			return err
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	tmp := d.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	write := func(r kvRecord) error {
+		frame, err := encodeKV(r)
+		if err == nil {
+			_, err = w.Write(frame)
+		}
+		return err
+	}
+	for k, values := range state.History {
+		if _, exists := state.Data[k]; exists {
+			continue
+		}
+		// history of deleted key
+		for i, v := range values {
+			err = write(kvRecord{key: k, value: v, tombstone: state.deleted(k, i)})
+			if err != nil {
+				file.Close()
+				return err
+			}
+		}
+	}
+	for k, v := range state.Data {
+		values := state.History[k]
+		last := len(values) - 1
+		if last < 0 || state.deleted(k, last) || !bytes.Equal(values[last], v) {
+			values = append(values, v)
+		}
+		for i, v := range values {
+			err = write(kvRecord{key: k, value: v, tombstone: state.deleted(k, i)})
+			if err != nil {
+				file.Close()
+				return err
+			}
+		}
+	}
+	for k, deadline := range state.Deadlines {
+		if err = write(expireRecord(k, deadline)); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if d.applying > 0 || state.Clock > 0 {
+		if _, err = w.Write(batchRecord(d.applying, state.Clock, nil)); err != nil {
+			file.Close()
+			return err
+		}
+		d.applying = 0
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+	if err := os.Rename(tmp, d.path); err != nil {
+		return err
+	}
+
+	d.file.Close()
+	if err := d.open(); err != nil {
+		return err
+	}
+	if len(snapshot) > 0 {
+		d.version = state.Version
+	}
+	d.dirty = false
+	return nil
+}
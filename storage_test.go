@@ -0,0 +1,158 @@
+package paxi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "1.1.db")
+
+	db, err := NewFileDatabase(path, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put("1", Value("a"))
+	if v, _ := db.Execute(Command{Key: "1", Value: Value("b")}); !bytes.Equal(v, Value("a")) {
+		t.Errorf("expected previous value a, got %s", v)
+	}
+	db.Put("2", Value("c"))
+	snapshot := db.Snapshot()
+
+	// reopen rebuilds index from file
+	db, err = NewFileDatabase(path, FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(db.Get("1"), Value("b")) || !bytes.Equal(db.Get("2"), Value("c")) {
+		t.Errorf("unexpected values after reopen %s %s", db.Get("1"), db.Get("2"))
+	}
+
+	db.Restore(nil)
+	if db.Get("1") != nil {
+		t.Errorf("expected empty database after reset, got %s", db.Get("1"))
+	}
+	if err := db.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(db.Get("1"), Value("b")) || !bytes.Equal(db.Get("2"), Value("c")) {
+		t.Errorf("unexpected values after restore %s %s", db.Get("1"), db.Get("2"))
+	}
+}
+
+func TestFileDatabaseDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "1.1.db")
+
+	db, _ := NewFileDatabase(path, FsyncNever)
+	db.Put("1", Value("a"))
+	db.Execute(Command{Key: "1", Op: OpDelete})
+	db, _ = NewFileDatabase(path, FsyncNever)
+	if v := db.Get("1"); v != nil {
+		t.Errorf("expected deleted key after reopen, got %s", v)
+	}
+}
+
+func TestFileDatabaseTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "1.1.db")
+
+	db, _ := NewFileDatabase(path, FsyncNever)
+	db.Execute(Command{Key: "1", Value: Value("a"), TTL: 10, Timestamp: 100})
+	db.Execute(Command{Key: "2", Value: Value("b"), TTL: 10, Timestamp: 100})
+	db.Execute(Command{Key: "2", Value: Value("c"), Timestamp: 101})
+	ttl := db.TTL("1")
+
+	db, err = NewFileDatabase(path, FsyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.TTL("1") != ttl || db.TTL("2") != 0 {
+		t.Errorf("unexpected deadlines after reopen %v %v, expected %v", db.TTL("1"), db.TTL("2"), ttl)
+	}
+	db.Execute(Command{Key: "3", Value: Value("d"), Timestamp: 200})
+	if db.Get("1") != nil || !bytes.Equal(db.Get("2"), Value("c")) {
+		t.Errorf("expected key 1 expired, got %s %s", db.Get("1"), db.Get("2"))
+	}
+}
+
+func TestFileDatabaseEmptyValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "1.1.db")
+
+	db, _ := NewFileDatabase(path, FsyncNever)
+	db.Put("1", Value{})
+	db.Put("2", Value("a"))
+	db.Execute(Command{Key: "2", Op: OpDelete})
+	db.Put("2", Value{})
+	snapshot := db.Snapshot()
+	db.Restore(nil)
+	if err := db.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if v := db.Get("1"); v == nil || len(v) != 0 {
+		t.Errorf("expected empty value of key 1 after restore, got %v", v)
+	}
+	if v := db.Get("2"); v == nil || len(v) != 0 {
+		t.Errorf("expected empty value of key 2 after restore, got %v", v)
+	}
+}
+
+func TestFileDatabaseApplied(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "1.1.db")
+
+	db, _ := NewFileDatabase(path, FsyncNever)
+	d := db.(Durable)
+	d.SetApplied(1)
+	db.Execute(Command{Key: "1", Value: Value("a")})
+	d.SetApplied(2)
+	v, _ := db.Execute(Command{Key: "1", Value: Value("b"), Op: OpAppend})
+	if string(v) != "ab" {
+		t.Errorf("expected value ab read within batch, got %s", v)
+	}
+	db.Put("2", Value("c"))
+
+	db, _ = NewFileDatabase(path, FsyncNever)
+	d = db.(Durable)
+	if d.Applied() != 2 || string(db.Get("1")) != "ab" || string(db.Get("2")) != "c" {
+		t.Errorf("unexpected state after reopen, applied %d values %s %s", d.Applied(), db.Get("1"), db.Get("2"))
+	}
+
+	snapshot := db.Snapshot()
+	d.SetApplied(5)
+	if err := db.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if d.Applied() != 5 || string(db.Get("1")) != "ab" {
+		t.Errorf("unexpected state after restore, applied %d value %s", d.Applied(), db.Get("1"))
+	}
+	db.Restore(nil)
+	if d.Applied() != 0 {
+		t.Errorf("expected no applied position after reset, got %d", d.Applied())
+	}
+}
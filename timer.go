@@ -0,0 +1,115 @@
+package paxi
+
+import (
+	"sync"
+	"time"
+)
+
+// timer delivers its message to node handler through message channel when it expires,
+// so that handlers of timeouts run in the same goroutine as every other message
+type timer struct {
+	sync.Mutex
+	n       *node
+	d       time.Duration
+	m       interface{}
+	repeat  bool  // ticker restarts after every expiration
+	stopped bool  // no more message is handled
+	clock   Timer // next expiration on node clock
+}
+
+// expiration is the message channel entry of expired timer
+type expiration struct {
+	t *timer
+}
+
+// timers are active timers of node
+type timers struct {
+	sync.Mutex
+	m map[*timer]bool
+}
+
+// After delivers message m to its registered handler after d unless the returned timer is stopped first
+func (n *node) After(d time.Duration, m interface{}) Timer {
+	return n.timer(d, m, false)
+}
+
+// Every delivers message m to its registered handler every d until the returned timer is stopped
+func (n *node) Every(d time.Duration, m interface{}) Timer {
+	return n.timer(d, m, true)
+}
+
+func (n *node) timer(d time.Duration, m interface{}, repeat bool) *timer {
+	t := &timer{n: n, d: d, m: m, repeat: repeat}
+	n.timers.Lock()
+	if n.timers.m == nil {
+		n.timers.m = make(map[*timer]bool)
+	}
+	n.timers.m[t] = true
+	n.timers.Unlock()
+	t.Lock()
+	t.clock = n.clock.AfterFunc(d, t.fire)
+	t.Unlock()
+	return t
+}
+
+// fire queues expiration of timer in message channel of node
+func (t *timer) fire() {
+	t.Lock()
+	if t.stopped {
+		t.Unlock()
+		return
+	}
+	if t.repeat {
+		t.clock = t.n.clock.AfterFunc(t.d, t.fire)
+	}
+	t.Unlock()
+	select {
+	case t.n.MessageChan <- expiration{t}:
+	case <-t.n.halt():
+	}
+}
+
+// expire returns true if message of expired timer should be handled
+func (t *timer) expire() bool {
+	t.Lock()
+	defer t.Unlock()
+	if t.stopped {
+		return false
+	}
+	if !t.repeat {
+		t.stopped = true
+		t.n.timers.remove(t)
+	}
+	return true
+}
+
+// Stop cancels timer, its message is not handled afterwards even if already expired,
+// returns false if timer is already stopped or its message handled
+func (t *timer) Stop() bool {
+	t.Lock()
+	defer t.Unlock()
+	if t.stopped {
+		return false
+	}
+	t.stopped = true
+	t.clock.Stop()
+	t.n.timers.remove(t)
+	return true
+}
+
+func (ts *timers) remove(t *timer) {
+	ts.Lock()
+	delete(ts.m, t)
+	ts.Unlock()
+}
+
+// stop cancels all active timers of node
+func (ts *timers) stop() {
+	ts.Lock()
+	active := ts.m
+	ts.m = nil
+	ts.Unlock()
+	for t := range active {
+		t.Stop()
+	}
+}
@@ -0,0 +1,44 @@
+package paxi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimer(t *testing.T) {
+	sim, _, received, done := faultyNodes(1)
+	defer done()
+	peer := sim.nodes["1.2"]
+
+	peer.After(time.Second, ping{N: 1})
+	cancelled := peer.After(time.Second, ping{N: 2})
+	ticker := peer.Every(400*time.Millisecond, ping{N: 3})
+	if !cancelled.Stop() {
+		t.Error("expected pending timer stopped")
+	}
+	sim.Run(1300 * time.Millisecond)
+	n := map[int]int{}
+	for _, p := range *received {
+		n[p.N]++
+	}
+	if n[1] != 1 || n[2] != 0 || n[3] != 3 {
+		t.Fatalf("unexpected timeouts %v", *received)
+	}
+	if sim.Now().Sub(epoch) != 1300*time.Millisecond {
+		t.Errorf("expected virtual time, got %v", sim.Now().Sub(epoch))
+	}
+
+	ticker.Stop()
+	sim.Run(3 * time.Second)
+	if len(*received) != 4 {
+		t.Errorf("expected no timeout after ticker stopped, got %v", *received)
+	}
+
+	// timers of crashed node are cancelled
+	peer.After(time.Second, ping{N: 4})
+	peer.Crash(1)
+	sim.Run(6 * time.Second)
+	if len(*received) != 4 {
+		t.Errorf("expected timer cancelled by crash, got %v", *received)
+	}
+}
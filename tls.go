@@ -0,0 +1,128 @@
+package paxi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// certificate file of id in configuration, "%s" in path is replaced by id
+func certFile(path string, id ID) string {
+	return strings.Replace(path, "%s", string(id), -1)
+}
+
+// tlsConfig creates mutual authentication configuration of node or client id
+// with its certificate and the CA that signs every certificate in deployment
+func tlsConfig(id ID) (*tls.Config, error) {
+	c := &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		MinVersion: tls.VersionTLS12,
+	}
+	if config.TLSCA == "" {
+		return nil, errors.New("tls_ca is not configured")
+	}
+	ca, err := ioutil.ReadFile(config.TLSCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s", config.TLSCA)
+	}
+	c.RootCAs = pool
+	c.ClientCAs = pool
+
+	// anonymous client without certificate of its own id can set one by HTTPClient.UseCertificate
+	if config.TLSCert != "" && (id != "" || !strings.Contains(config.TLSCert, "%s")) {
+		cert, err := tls.LoadX509KeyPair(certFile(config.TLSCert, id), certFile(config.TLSKey, id))
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// identifies returns true if certificate is issued to id by common name or DNS name
+func identifies(cert *x509.Certificate, id ID) bool {
+	if cert.Subject.CommonName == string(id) {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if name == string(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// certNode returns configured node id the certificate is issued to, empty if none
+func certNode(cert *x509.Certificate) ID {
+	for id := range config.Addrs {
+		if identifies(cert, id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// addrNode returns configured node id of address host, empty if none
+func addrNode(host string) ID {
+	for id, addr := range config.Addrs {
+		if u, err := url.Parse(addr); err == nil && u.Host == host {
+			return id
+		}
+	}
+	return ""
+}
+
+// verifyNode accepts only peer certificate issued to node id, or to any configured node if id is empty,
+// the certificate chain is already verified by CA
+func verifyNode(id ID) func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		for _, chain := range chains {
+			if len(chain) == 0 {
+				continue
+			}
+			if id != "" && identifies(chain[0], id) || id == "" && certNode(chain[0]) != "" {
+				return nil
+			}
+		}
+		if id != "" {
+			return fmt.Errorf("peer certificate is not issued to node %s", id)
+		}
+		return errors.New("peer certificate is not a configured node")
+	}
+}
+
+// verifyHost checks that accepted connection comes from the configured host of node its certificate is issued to
+func verifyHost(conn *tls.Conn) error {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return errors.New("no peer certificate")
+	}
+	id := certNode(certs[0])
+	u, err := url.Parse(config.Addrs[id])
+	if err != nil {
+		return err
+	}
+	remote, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return err
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if ip.Equal(net.ParseIP(remote)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("node %s connects from %s instead of its configured host %s", id, remote, u.Hostname())
+}
@@ -0,0 +1,112 @@
+package paxi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/gob"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert generates certificate of common name signed by parent into dir, self-signed CA if parent is nil
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestTLSTransport(t *testing.T) {
+	gob.Register(MSG{})
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "1.1", ca, caKey)
+	writeCert(t, dir, "1.2", ca, caKey)
+	writeCert(t, dir, "9.9", ca, caKey)
+
+	c := config
+	defer func() { config = c }()
+	config.TLSCA = filepath.Join(dir, "ca.crt")
+	config.TLSCert = filepath.Join(dir, "%s.crt")
+	config.TLSKey = filepath.Join(dir, "%s.key")
+	config.Addrs = map[ID]string{
+		"1.1": "tls://127.0.0.1:1745",
+		"1.2": "tls://127.0.0.1:1746",
+	}
+
+	server := newTransport(config.Addrs["1.1"], "1.1")
+	server.Listen()
+
+	client := newTransport(config.Addrs["1.1"], "1.2")
+	if err := client.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	client.Send(MSG{1, "hello"})
+	if m := server.Recv(); m.(MSG) != (MSG{1, "hello"}) {
+		t.Errorf("unexpected message %v", m)
+	}
+
+	// certificate of unknown node is rejected
+	intruder, err := tlsConfig("9.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", "127.0.0.1:1745", intruder)
+	if err == nil {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if e, ok := err.(net.Error); err == nil || ok && e.Timeout() {
+		t.Errorf("expected connection of unknown node rejected, got %v", err)
+	}
+
+	// node listening at address of another node is rejected by dialer
+	impostor := newTransport(config.Addrs["1.2"], "1.1")
+	impostor.Listen()
+	defer impostor.Close()
+	dialer, _ := tlsConfig("1.1")
+	dialer.VerifyPeerCertificate = verifyNode(addrNode("127.0.0.1:1746"))
+	conn, err = tls.Dial("tcp", "127.0.0.1:1746", dialer)
+	if err == nil {
+		conn.Close()
+		t.Error("expected certificate of node 1.1 rejected at address of node 1.2")
+	}
+}
@@ -1,19 +1,20 @@
 package paxi
 
 import (
-	"bytes"
-	"encoding/gob"
+	"crypto/tls"
 	"errors"
 	"flag"
+	"io"
 	"net"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ailidani/paxi/log"
 )
 
-var scheme = flag.String("transport", "tcp", "transport scheme (tcp, udp, chan), default tcp")
+var scheme = flag.String("transport", "tcp", "transport scheme (tcp, tls, udp, chan), default tcp")
 
 // Transport = transport + pipe + client + server
 type Transport interface {
@@ -27,17 +28,26 @@ type Transport interface {
 	Recv() interface{}
 
 	// Dial connects to remote server non-blocking once connected
+	// stream transports keep reconnecting in background and never fail after address is resolved
 	Dial() error
 
+	// State returns the state of outbound connection
+	State() PeerState
+
 	// Listen waits for connections, non-blocking once listener starts
 	Listen()
 
-	// Close closes send channel and stops listener
+	// Close stops listener and connections, Send and Recv return immediately after
 	Close()
 }
 
 // NewTransport creates new transport object with url
 func NewTransport(addr string) Transport {
+	return newTransport(addr, "")
+}
+
+// newTransport creates new transport object with url for local node id
+func newTransport(addr string, id ID) Transport {
 	if !strings.Contains(addr, "://") {
 		addr = *scheme + "://" + addr
 	}
@@ -47,12 +57,20 @@ func NewTransport(addr string) Transport {
 	}
 
 	transport := &transport{
+		id:    id,
 		uri:   uri,
 		send:  make(chan interface{}, config.ChanBufferSize),
 		recv:  make(chan interface{}, config.ChanBufferSize),
 		close: make(chan struct{}),
 	}
 
+	if simulator != nil {
+		t := new(simTransport)
+		t.transport = transport
+		t.sim = simulator
+		return t
+	}
+
 	switch uri.Scheme {
 	case "chan":
 		t := new(channel)
@@ -66,6 +84,15 @@ func NewTransport(addr string) Transport {
 		t := new(udp)
 		t.transport = transport
 		return t
+	case "tls":
+		t := new(tlsTransport)
+		t.transport = transport
+		t.config, err = tlsConfig(id)
+		if err != nil {
+			log.Fatalf("tls transport error: %v", err)
+		}
+		t.config.VerifyPeerCertificate = verifyNode("")
+		return t
 	default:
 		log.Fatalf("unknown scheme %s", uri.Scheme)
 	}
@@ -73,23 +100,72 @@ func NewTransport(addr string) Transport {
 }
 
 type transport struct {
+	id    ID // local node id
 	uri   *url.URL
 	send  chan interface{}
 	recv  chan interface{}
 	close chan struct{}
+	once  sync.Once
+	peer  peer
 }
 
+// Send puts message into bounded outbound queue, full queue drops the message unless block policy is configured
 func (t *transport) Send(m interface{}) {
-	t.send <- m
+	if config.QueuePolicy == QueueBlock {
+		select {
+		case t.send <- m:
+		case <-t.close:
+		}
+		return
+	}
+	select {
+	case t.send <- m:
+	case <-t.close:
+	default:
+		t.peer.dropped()
+	}
+}
+
+func (t *transport) State() PeerState {
+	t.peer.RLock()
+	defer t.peer.RUnlock()
+	state := t.peer.state
+	state.Queued = len(t.send)
+	return state
 }
 
+// Recv returns nil once transport is closed
 func (t *transport) Recv() interface{} {
-	return <-t.recv
+	select {
+	case m := <-t.recv:
+		return m
+	case <-t.close:
+		return nil
+	}
 }
 
 func (t *transport) Close() {
-	close(t.send)
-	close(t.close)
+	t.once.Do(func() {
+		close(t.close)
+	})
+}
+
+// flush writes messages left in send queue when transport is closed, until write fails
+func (t *transport) flush(write func(m interface{}) error) {
+	for len(t.send) > 0 {
+		if write(<-t.send) != nil {
+			return
+		}
+	}
+}
+
+func (t *transport) closed() bool {
+	select {
+	case <-t.close:
+		return true
+	default:
+		return false
+	}
 }
 
 func (t *transport) Scheme() string {
@@ -97,25 +173,57 @@ func (t *transport) Scheme() string {
 }
 
 func (t *transport) Dial() error {
-	conn, err := net.Dial(t.Scheme(), t.uri.Host)
-	if err != nil {
-		return err
-	}
+	go t.connect(func() (net.Conn, error) {
+		return net.DialTimeout(t.Scheme(), t.uri.Host, maxBackoff)
+	})
+	return nil
+}
 
-	go func(conn net.Conn) {
-		// w := bufio.NewWriter(conn)
-		// codec := NewCodec(config.Codec, conn)
-		encoder := gob.NewEncoder(conn)
-		defer conn.Close()
-		for m := range t.send {
-			err := encoder.Encode(&m)
+// read decodes messages from connection into recv channel until connection or transport is closed
+func (t *transport) read(conn net.Conn) {
+	codec := newCodec(conn)
+	batches := newUnbatcher()
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// unblock decoding when transport is closed
+		select {
+		case <-t.close:
+			conn.Close()
+		case <-done:
+		}
+	}()
+	for {
+		select {
+		case <-t.close:
+			return
+		default:
+			var m interface{}
+			err := codec.Decode(&m)
 			if err != nil {
-				log.Error(err)
+				// stream cannot recover after error, peer reconnects
+				if err != io.EOF && !t.closed() {
+					log.Errorf("connection from %v broken: %v", conn.RemoteAddr(), err)
+				}
+				return
 			}
+			t.deliver(m, batches)
 		}
-	}(conn)
+	}
+}
 
-	return nil
+// newCodec creates codec of configuration for messages between nodes
+func newCodec(rw io.ReadWriter) Codec {
+	scheme := config.Codec
+	if scheme == "" {
+		scheme = "gob"
+	}
+	codec := NewCodec(scheme, rw)
+	if codec == nil || codec.Scheme() == "json" {
+		log.Fatalf("unsupported codec %s between nodes", config.Codec)
+	}
+	return codec
 }
 
 /******************************
@@ -131,104 +239,84 @@ func (t *tcp) Listen() {
 	if err != nil {
 		log.Fatal("TCP Listener error: ", err)
 	}
+	go func() {
+		<-t.close
+		listener.Close()
+	}()
 
 	go func(listener net.Listener) {
 		defer listener.Close()
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
+				if t.closed() {
+					return
+				}
 				log.Error("TCP Accept error: ", err)
 				continue
 			}
 
-			go func(conn net.Conn) {
-				// codec := NewCodec(config.Codec, conn)
-				decoder := gob.NewDecoder(conn)
-				defer conn.Close()
-				//r := bufio.NewReader(conn)
-				for {
-					select {
-					case <-t.close:
-						return
-					default:
-						var m interface{}
-						err := decoder.Decode(&m)
-						if err != nil {
-							log.Error(err)
-							continue
-						}
-						t.recv <- m
-					}
-				}
-			}(conn)
-
+			go t.read(conn)
 		}
 	}(listener)
 }
 
 /******************************
-/*     UDP communication      *
+/*     TLS communication      *
 /******************************/
-type udp struct {
+type tlsTransport struct {
 	*transport
+	config *tls.Config
 }
 
-func (u *udp) Dial() error {
-	addr, err := net.ResolveUDPAddr("udp", u.uri.Host)
-	if err != nil {
-		log.Fatal("UDP resolve address error: ", err)
-	}
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		return err
-	}
-
-	go func(conn *net.UDPConn) {
-		// packet := make([]byte, 1500)
-		// w := bytes.NewBuffer(packet)
-		w := new(bytes.Buffer)
-		for m := range u.send {
-			gob.NewEncoder(w).Encode(&m)
-			_, err := conn.Write(w.Bytes())
-			if err != nil {
-				log.Error(err)
-			}
-			w.Reset()
-		}
-	}(conn)
-
+func (t *tlsTransport) Dial() error {
+	dialer := &net.Dialer{Timeout: maxBackoff}
+	// dialed node must present certificate of the node configured at the address
+	c := t.config.Clone()
+	c.VerifyPeerCertificate = verifyNode(addrNode(t.uri.Host))
+	go t.connect(func() (net.Conn, error) {
+		return tls.DialWithDialer(dialer, "tcp", t.uri.Host, c)
+	})
 	return nil
 }
 
-func (u *udp) Listen() {
-	addr, err := net.ResolveUDPAddr("udp", ":"+u.uri.Port())
+func (t *tlsTransport) Listen() {
+	log.Debug("start tls listening ", t.uri.Port())
+	listener, err := tls.Listen("tcp", ":"+t.uri.Port(), t.config)
 	if err != nil {
-		log.Fatal("UDP resolve address error: ", err)
+		log.Fatal("TLS Listener error: ", err)
 	}
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		log.Fatal("UDP Listener error: ", err)
-	}
-	go func(conn *net.UDPConn) {
-		packet := make([]byte, 1500)
-		defer conn.Close()
+	go func() {
+		<-t.close
+		listener.Close()
+	}()
+
+	go func(listener net.Listener) {
+		defer listener.Close()
 		for {
-			select {
-			case <-u.close:
-				return
-			default:
-				_, err := conn.Read(packet)
-				if err != nil {
-					log.Error(err)
-					continue
+			conn, err := listener.Accept()
+			if err != nil {
+				if t.closed() {
+					return
 				}
-				r := bytes.NewReader(packet)
-				var m interface{}
-				gob.NewDecoder(r).Decode(&m)
-				u.recv <- m
+				log.Error("TLS Accept error: ", err)
+				continue
 			}
+			go func(conn *tls.Conn) {
+				// peer must authenticate before any message is read
+				err := conn.Handshake()
+				if err == nil {
+					err = verifyHost(conn)
+				}
+				if err != nil {
+					log.Errorf("TLS handshake with %v error: %v", conn.RemoteAddr(), err)
+					conn.Close()
+					return
+				}
+				t.read(conn)
+			}(conn.(*tls.Conn))
 		}
-	}(conn)
+	}(listener)
 }
 
 /*******************************
@@ -246,32 +334,70 @@ func (c *channel) Scheme() string {
 	return "chan"
 }
 
+// Dial waits with backoff until server of the address listens, messages are queued meanwhile
 func (c *channel) Dial() error {
-	chansLock.RLock()
-	defer chansLock.RUnlock()
-	conn, ok := chans[c.uri.Host]
-	if !ok {
-		return errors.New("server not ready")
+	lookup := func() (chan<- interface{}, bool) {
+		chansLock.RLock()
+		defer chansLock.RUnlock()
+		conn, ok := chans[c.uri.Host]
+		return conn, ok
 	}
-	go func(conn chan<- interface{}) {
-		for m := range c.send {
-			conn <- m
+	go func() {
+		conn, ok := lookup()
+		for !ok {
+			c.peer.disconnected(errors.New("server not ready"))
+			select {
+			case <-c.close:
+				return
+			case <-time.After(backoff(c.State().Retries)):
+			}
+			conn, ok = lookup()
+		}
+		c.peer.connected()
+		// after close messages are written as long as the channel has space
+		write := func(m interface{}) error {
+			select {
+			case conn <- m:
+				return nil
+			default:
+				return errors.New("channel full")
+			}
+		}
+		for {
+			select {
+			case <-c.close:
+				c.flush(write)
+				return
+			case m := <-c.send:
+				select {
+				case conn <- m:
+				case <-c.close:
+					if write(m) == nil {
+						c.flush(write)
+					}
+					return
+				}
+			}
 		}
-	}(conn)
+	}()
 	return nil
 }
 
 func (c *channel) Listen() {
 	chansLock.Lock()
 	defer chansLock.Unlock()
-	chans[c.uri.Host] = make(chan interface{}, config.ChanBufferSize)
+	// channel outlives transport so that restarted node of the same address receives from dialed peers
+	if _, exists := chans[c.uri.Host]; !exists {
+		chans[c.uri.Host] = make(chan interface{}, config.ChanBufferSize)
+	}
 	go func(conn <-chan interface{}) {
 		for {
 			select {
 			case <-c.close:
 				return
 			case m := <-conn:
-				c.recv <- m
+				// channel never batches messages
+				c.put(m)
 			}
 		}
 	}(chans[c.uri.Host])
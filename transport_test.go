@@ -2,7 +2,11 @@ package paxi
 
 import (
 	"encoding/gob"
+	"net"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestTransport(t *testing.T) {
@@ -57,3 +61,164 @@ func TestTransport(t *testing.T) {
 		}
 	}
 }
+
+func TestTransportReconnect(t *testing.T) {
+	gob.Register(A{})
+
+	// peer starts after dial
+	client := NewTransport("tcp://127.0.0.1:1747")
+	if err := client.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	client.Send(A{I: 1})
+	time.Sleep(100 * time.Millisecond)
+	if s := client.State(); s.Connected || s.Retries == 0 || s.Queued != 1 {
+		t.Errorf("unexpected state before peer starts %+v", s)
+	}
+
+	server := NewTransport("tcp://127.0.0.1:1747")
+	server.Listen()
+	if m := server.Recv(); m.(A).I != 1 {
+		t.Errorf("unexpected message %v", m)
+	}
+	if s := client.State(); !s.Connected || s.Retries != 0 {
+		t.Errorf("unexpected state after peer starts %+v", s)
+	}
+	client.Close()
+}
+
+func TestTransportQueueDrop(t *testing.T) {
+	c := config
+	defer func() { config = c }()
+	config.QueuePolicy = QueueDrop
+	config.ChanBufferSize = 2
+
+	client := NewTransport("tcp://127.0.0.1:1748")
+	for i := 0; i < 5; i++ {
+		client.Send(A{I: i})
+	}
+	if s := client.State(); s.Queued != 2 || s.Dropped != 3 {
+		t.Errorf("expected 2 queued and 3 dropped messages, got %+v", s)
+	}
+}
+
+func TestUDPFragmentation(t *testing.T) {
+	gob.Register(A{})
+
+	server := NewTransport("udp://127.0.0.1:1749")
+	server.Listen()
+	client := NewTransport("udp://127.0.0.1:1749")
+	client.Dial()
+
+	// message larger than one datagram
+	large := strings.Repeat("paxi", 2000)
+	client.Send(A{I: 1, S: large})
+	if m := server.Recv(); m.(A).S != large {
+		t.Errorf("unexpected reassembled message of %d bytes", len(m.(A).S))
+	}
+	if s := client.State().Datagrams; s == nil || s.Sent < 2 {
+		t.Errorf("expected message sent in fragments, got %+v", s)
+	}
+
+	// corrupted datagram is counted and dropped
+	conn, err := net.Dial("udp", "127.0.0.1:1749")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := encodeFragment(fragment{seq: 1, count: 1, payload: []byte("garbage")})
+	d[len(d)-1] ^= 0xff
+	conn.Write(d)
+	conn.Close()
+
+	client.Send(A{I: 2})
+	if m := server.Recv(); m.(A).I != 2 {
+		t.Errorf("unexpected message %v", m)
+	}
+	if s := server.State().Datagrams; s == nil || s.Corrupt != 1 {
+		t.Errorf("expected 1 corrupt datagram, got %+v", s)
+	}
+	client.Close()
+	server.Close()
+}
+
+func TestReassemblyTimeout(t *testing.T) {
+	r := &reassembler{partials: make(map[partialKey]*partial)}
+	now := time.Now()
+	fragments := fragmentize(1, make([]byte, 3*fragmentSize))
+	r.add("a", fragments[0], now)
+	if data, _ := r.add("a", fragments[2], now); data != nil {
+		t.Error("expected incomplete message")
+	}
+	_, expired := r.add("b", fragments[0], now.Add(2*reassemblyTimeout))
+	if expired != 1 {
+		t.Errorf("expected 1 expired message, got %d", expired)
+	}
+	if data, _ := r.add("a", fragments[1], now.Add(2*reassemblyTimeout)); data != nil {
+		t.Error("expected message dropped after timeout")
+	}
+}
+
+func TestTransportBatch(t *testing.T) {
+	gob.Register(A{})
+	c := config
+	defer func() { config = c }()
+	config.Batching = true
+	config.BatchInterval = 10000
+
+	for i, codec := range []string{"gob", "binary"} {
+		config.Codec = codec
+		addr := "tcp://127.0.0.1:" + strconv.Itoa(1750+i)
+
+		// queued messages are coalesced into one batch
+		client := NewTransport(addr).(*tcp)
+		batches := newBatcher()
+		for j := 0; j < 3; j++ {
+			client.Send(A{I: j})
+		}
+		m, _ := client.batch(<-client.send, batches)
+		b, ok := m.(Batch)
+		if !ok {
+			t.Fatalf("%s: expected batch", codec)
+		}
+		unbatches := newUnbatcher()
+		msgs, err := unbatches.messages(b)
+		if err != nil || len(msgs) != 3 || msgs[2].(A).I != 2 {
+			t.Errorf("%s: unexpected batch messages %v %v", codec, msgs, err)
+		}
+
+		// later batch of connection is decoded by the same codec
+		for j := 3; j < 6; j++ {
+			client.Send(A{I: j})
+		}
+		m, _ = client.batch(<-client.send, batches)
+		next := m.(Batch)
+		if codec == "gob" && len(next.Data) >= len(b.Data) {
+			t.Errorf("%s: expected batch without type information, got %d bytes of %d", codec, len(next.Data), len(b.Data))
+		}
+		msgs, err = unbatches.messages(next)
+		if err != nil || len(msgs) != 3 || msgs[2].(A).I != 5 {
+			t.Errorf("%s: unexpected later batch messages %v %v", codec, msgs, err)
+		}
+
+		// single message is sent as is without waiting
+		client.Send(A{I: 6})
+		if m, _ := client.batch(<-client.send, batches); m.(A).I != 6 {
+			t.Errorf("%s: expected single message, got %v", codec, m)
+		}
+
+		// batches are unpacked in order on receive
+		server := NewTransport(addr)
+		server.Listen()
+		client.Dial()
+		for j := 3; j < 100; j++ {
+			client.Send(A{I: j})
+		}
+		for j := 3; j < 100; j++ {
+			if m := server.Recv(); m.(A).I != j {
+				t.Fatalf("%s: expected message %d, got %v", codec, j, m)
+			}
+		}
+		client.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package paxi
+
+import (
+	"sort"
+	"time"
+)
+
+// expiry tracks deadlines of keys with time to live in logical time of database
+// the logical clock is the largest timestamp of executed write commands,
+// so every replica executing the same log expires the same keys at the same point
+type expiry struct {
+	clock     int64
+	deadlines map[Key]int64
+}
+
+// now returns logical time of command execution
+func (e *expiry) now(t int64) int64 {
+	if t > e.clock {
+		return t
+	}
+	return e.clock
+}
+
+// expired checks if key has passed its deadline at logical time now
+func (e *expiry) expired(k Key, now int64) bool {
+	d, exists := e.deadlines[k]
+	return exists && d <= now
+}
+
+// advance moves the clock forward to t and returns expired keys in order
+func (e *expiry) advance(t int64) []Key {
+	e.clock = e.now(t)
+	keys := make([]Key, 0)
+	for k, d := range e.deadlines {
+		if d <= e.clock {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// set sets the deadline of key, 0 clears it
+func (e *expiry) set(k Key, deadline int64) {
+	if e.deadlines == nil {
+		e.deadlines = make(map[Key]int64)
+	}
+	if deadline == 0 {
+		delete(e.deadlines, k)
+		return
+	}
+	e.deadlines[k] = deadline
+}
+
+// ttl returns remaining time to live of key at current clock, 0 if key never expires
+func (e *expiry) ttl(k Key) time.Duration {
+	d, exists := e.deadlines[k]
+	if !exists {
+		return 0
+	}
+	return time.Duration(d - e.clock)
+}
+
+func (e *expiry) clone() expiry {
+	c := expiry{clock: e.clock}
+	for k, d := range e.deadlines {
+		c.set(k, d)
+	}
+	return c
+}
+
+// refresh sets deadline of key written by command from its TTL,
+// write that replaces the value without TTL makes the key persistent again
+func refresh(s kv, c Command, replace bool) {
+	e := s.expiry()
+	if c.TTL > 0 {
+		s.expire(c.Key, e.clock+int64(c.TTL))
+	} else if _, exists := e.deadlines[c.Key]; exists && replace {
+		s.expire(c.Key, 0)
+	}
+}
@@ -0,0 +1,145 @@
+package paxi
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// NewTxn creates a command that executes all commands atomically in order
+// the key of transaction command is the key of its first command,
+// and the result value is JSON encoded list of every command result
+func NewTxn(cmds []Command) Command {
+	v, _ := json.Marshal(cmds)
+	c := Command{Value: v, Op: OpTxn}
+	if len(cmds) > 0 {
+		c.Key = cmds[0].Key
+	}
+	return c
+}
+
+// Commands returns the commands in transaction command
+func (c Command) Commands() []Command {
+	if c.Operation() != OpTxn {
+		return []Command{c}
+	}
+	cmds := make([]Command, 0)
+	if err := json.Unmarshal(c.Value, &cmds); err != nil {
+		log.Errorf("invalid transaction %s", c.Value)
+	}
+	return cmds
+}
+
+// Keys returns all distinct keys accessed by command in order
+func (c Command) Keys() []Key {
+	keys := make([]Key, 0)
+	set := make(map[Key]bool)
+	for _, cmd := range c.Commands() {
+		if !set[cmd.Key] {
+			set[cmd.Key] = true
+			keys = append(keys, cmd.Key)
+		}
+	}
+	return keys
+}
+
+// write is one buffered write in transaction
+type write struct {
+	key      Key
+	value    Value
+	deleted  bool
+	expire   bool // deadline change of key
+	deadline int64
+}
+
+// overlay buffers writes of transaction on top of storage engine
+type overlay struct {
+	kv
+	writes  []write
+	latest  map[Key]int // index of latest write of key
+	expires expiry      // deadlines of keys as changed by transaction
+}
+
+func (o *overlay) get(k Key) Value {
+	if i, exists := o.latest[k]; exists {
+		return o.writes[i].value
+	}
+	return o.kv.get(k)
+}
+
+func (o *overlay) put(k Key, v Value) {
+	if v == nil {
+		return
+	}
+	o.latest[k] = len(o.writes)
+	o.writes = append(o.writes, write{key: k, value: v})
+}
+
+func (o *overlay) delete(k Key) {
+	o.expires.set(k, 0)
+	o.latest[k] = len(o.writes)
+	o.writes = append(o.writes, write{key: k, deleted: true})
+}
+
+func (o *overlay) expiry() *expiry {
+	return &o.expires
+}
+
+func (o *overlay) expire(k Key, deadline int64) {
+	o.expires.set(k, deadline)
+	o.writes = append(o.writes, write{key: k, expire: true, deadline: deadline})
+}
+
+func (o *overlay) keys(from, to Key, limit int) []Key {
+	set := make(map[Key]bool)
+	for _, k := range o.kv.keys(from, to, 0) {
+		set[k] = true
+	}
+	for k, i := range o.latest {
+		if k >= from && (to == "" || k < to) {
+			set[k] = !o.writes[i].deleted
+		}
+	}
+	keys := make(sortedKeys, 0, len(set))
+	for k, exists := range set {
+		if exists {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys.scan(from, to, limit)
+}
+
+// executeTxn executes every command of transaction against overlay of s, and applies the writes to s only if all commands succeed
+func executeTxn(s kv, c Command) (Value, error) {
+	o := &overlay{
+		kv:      s,
+		writes:  make([]write, 0),
+		latest:  make(map[Key]int),
+		expires: s.expiry().clone(),
+	}
+	values := make([]Value, 0)
+	for _, cmd := range c.Commands() {
+		if cmd.Operation() == OpTxn {
+			return nil, ErrNotSupported
+		}
+		v, err := execute(o, cmd)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	for _, w := range o.writes {
+		if w.expire {
+			s.expire(w.key, w.deadline)
+		} else if w.deleted {
+			if s.get(w.key) != nil {
+				s.delete(w.key)
+			}
+		} else {
+			s.put(w.key, w.value)
+		}
+	}
+	return json.Marshal(values)
+}
@@ -0,0 +1,300 @@
+package paxi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ailidani/paxi/log"
+)
+
+// every datagram carries one fragment of a message as
+// [version byte][crc32 uint32][message sequence uint32][fragment index uint16][fragment count uint16][payload]
+// the checksum covers everything after it
+const (
+	datagramVersion   byte = 1
+	datagramHeader         = 13
+	fragmentSize           = 1400 // payload bytes per datagram to stay under common MTU
+	maxDatagram            = 65535
+	maxFragments           = 1<<16 - 1
+	reassemblyTimeout      = time.Second // incomplete message is dropped after timeout
+)
+
+var errDatagram = errors.New("malformed datagram")
+
+// DatagramStats counts datagrams and messages of udp transport
+type DatagramStats struct {
+	Sent       int64 `json:"sent"`       // datagrams sent
+	Received   int64 `json:"received"`   // datagrams received
+	Corrupt    int64 `json:"corrupt"`    // datagrams dropped by malformed header or checksum mismatch
+	Incomplete int64 `json:"incomplete"` // messages dropped by reassembly timeout
+	Invalid    int64 `json:"invalid"`    // reassembled messages that cannot be decoded
+	Oversize   int64 `json:"oversize"`   // outbound messages too large to be fragmented
+}
+
+// fragment is one datagram of message
+type fragment struct {
+	seq     uint32
+	index   uint16
+	count   uint16
+	payload []byte
+}
+
+func encodeFragment(f fragment) []byte {
+	d := make([]byte, datagramHeader+len(f.payload))
+	d[0] = datagramVersion
+	binary.BigEndian.PutUint32(d[5:9], f.seq)
+	binary.BigEndian.PutUint16(d[9:11], f.index)
+	binary.BigEndian.PutUint16(d[11:13], f.count)
+	copy(d[datagramHeader:], f.payload)
+	binary.BigEndian.PutUint32(d[1:5], crc32.ChecksumIEEE(d[5:]))
+	return d
+}
+
+func decodeFragment(d []byte) (fragment, error) {
+	var f fragment
+	if len(d) < datagramHeader || d[0] != datagramVersion {
+		return f, errDatagram
+	}
+	if crc32.ChecksumIEEE(d[5:]) != binary.BigEndian.Uint32(d[1:5]) {
+		return f, errDatagram
+	}
+	f.seq = binary.BigEndian.Uint32(d[5:9])
+	f.index = binary.BigEndian.Uint16(d[9:11])
+	f.count = binary.BigEndian.Uint16(d[11:13])
+	if f.count == 0 || f.index >= f.count {
+		return f, errDatagram
+	}
+	f.payload = append([]byte(nil), d[datagramHeader:]...)
+	return f, nil
+}
+
+// fragmentize splits encoded message into fragments of sequence number seq
+func fragmentize(seq uint32, data []byte) []fragment {
+	count := (len(data) + fragmentSize - 1) / fragmentSize
+	if count == 0 {
+		count = 1
+	}
+	fragments := make([]fragment, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * fragmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		fragments = append(fragments, fragment{
+			seq:     seq,
+			index:   uint16(i),
+			count:   uint16(count),
+			payload: data[i*fragmentSize : end],
+		})
+	}
+	return fragments
+}
+
+// partial is message in reassembly
+type partial struct {
+	fragments [][]byte
+	received  int
+	deadline  time.Time
+}
+
+// partialKey identifies message by sender address and sequence
+type partialKey struct {
+	from string
+	seq  uint32
+}
+
+// reassembler collects fragments of messages from all senders
+type reassembler struct {
+	partials map[partialKey]*partial
+	sweep    time.Time // next time to drop expired partial messages
+}
+
+// add adds fragment from sender and returns the whole message once all fragments arrived
+// returns number of incomplete messages dropped by timeout
+func (r *reassembler) add(from string, f fragment, now time.Time) ([]byte, int) {
+	expired := 0
+	if now.After(r.sweep) {
+		for k, p := range r.partials {
+			if now.After(p.deadline) {
+				delete(r.partials, k)
+				expired++
+			}
+		}
+		r.sweep = now.Add(reassemblyTimeout)
+	}
+
+	if f.count == 1 {
+		return f.payload, expired
+	}
+	key := partialKey{from, f.seq}
+	p, exists := r.partials[key]
+	if !exists || len(p.fragments) != int(f.count) {
+		p = &partial{fragments: make([][]byte, f.count)}
+		r.partials[key] = p
+	}
+	p.deadline = now.Add(reassemblyTimeout)
+	if p.fragments[f.index] == nil {
+		p.fragments[f.index] = f.payload
+		p.received++
+	}
+	if p.received < len(p.fragments) {
+		return nil, expired
+	}
+	delete(r.partials, key)
+	return bytes.Join(p.fragments, nil), expired
+}
+
+// udp transport sends every message as checksummed datagram fragments
+type udp struct {
+	*transport
+	seq     uint32
+	stats   DatagramStats
+	running sync.WaitGroup // goroutines of connection and listener
+}
+
+// Stats returns datagram counters of transport
+func (u *udp) Stats() DatagramStats {
+	return DatagramStats{
+		Sent:       atomic.LoadInt64(&u.stats.Sent),
+		Received:   atomic.LoadInt64(&u.stats.Received),
+		Corrupt:    atomic.LoadInt64(&u.stats.Corrupt),
+		Incomplete: atomic.LoadInt64(&u.stats.Incomplete),
+		Invalid:    atomic.LoadInt64(&u.stats.Invalid),
+		Oversize:   atomic.LoadInt64(&u.stats.Oversize),
+	}
+}
+
+// State returns state of outbound connection with datagram counters
+func (u *udp) State() PeerState {
+	state := u.transport.State()
+	stats := u.Stats()
+	state.Datagrams = &stats
+	return state
+}
+
+// Close stops connection and listener and waits for them to exit
+func (u *udp) Close() {
+	u.transport.Close()
+	u.running.Wait()
+}
+
+func (u *udp) Dial() error {
+	addr, err := net.ResolveUDPAddr("udp", u.uri.Host)
+	if err != nil {
+		log.Fatal("UDP resolve address error: ", err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	u.peer.connected()
+
+	u.running.Add(1)
+	go func(conn *net.UDPConn) {
+		defer u.running.Done()
+		defer conn.Close()
+		w := new(bytes.Buffer)
+		for {
+			select {
+			case m := <-u.send:
+				u.write(conn, w, m)
+			case <-u.close:
+				u.flush(func(m interface{}) error {
+					return u.write(conn, w, m)
+				})
+				return
+			}
+		}
+	}(conn)
+
+	return nil
+}
+
+// write sends message as datagram fragments, message that cannot be sent is dropped
+func (u *udp) write(conn *net.UDPConn, w *bytes.Buffer, m interface{}) error {
+	w.Reset()
+	err := newCodec(w).Encode(&m)
+	if err != nil {
+		u.peer.dropped()
+		return err
+	}
+	if w.Len() > maxFragments*fragmentSize {
+		log.Errorf("drop message of %d bytes to %s: too large for udp", w.Len(), u.uri)
+		atomic.AddInt64(&u.stats.Oversize, 1)
+		u.peer.dropped()
+		return nil
+	}
+	u.seq++
+	for _, f := range fragmentize(u.seq, w.Bytes()) {
+		_, err := conn.Write(encodeFragment(f))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		atomic.AddInt64(&u.stats.Sent, 1)
+	}
+	return nil
+}
+
+func (u *udp) Listen() {
+	addr, err := net.ResolveUDPAddr("udp", ":"+u.uri.Port())
+	if err != nil {
+		log.Fatal("UDP resolve address error: ", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		log.Fatal("UDP Listener error: ", err)
+	}
+	go func() {
+		<-u.close
+		conn.Close()
+	}()
+	u.running.Add(1)
+	go func(conn *net.UDPConn) {
+		defer u.running.Done()
+		packet := make([]byte, maxDatagram)
+		r := &reassembler{partials: make(map[partialKey]*partial)}
+		defer conn.Close()
+		for {
+			select {
+			case <-u.close:
+				return
+			default:
+				n, from, err := conn.ReadFromUDP(packet)
+				if err != nil {
+					if u.closed() {
+						return
+					}
+					log.Error(err)
+					continue
+				}
+				atomic.AddInt64(&u.stats.Received, 1)
+				f, err := decodeFragment(packet[:n])
+				if err != nil {
+					log.Debugf("drop datagram from %v: %v", from, err)
+					atomic.AddInt64(&u.stats.Corrupt, 1)
+					continue
+				}
+				data, expired := r.add(from.String(), f, time.Now())
+				atomic.AddInt64(&u.stats.Incomplete, int64(expired))
+				if data == nil {
+					continue
+				}
+				var m interface{}
+				err = newCodec(bytes.NewBuffer(data)).Decode(&m)
+				if err != nil {
+					log.Errorf("drop message from %v: %v", from, err)
+					atomic.AddInt64(&u.stats.Invalid, 1)
+					continue
+				}
+				u.put(m)
+			}
+		}
+	}(conn)
+}
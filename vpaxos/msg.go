@@ -86,7 +86,7 @@ type Query struct {
 }
 
 func (m Query) String() string {
-	return fmt.Sprintf("Query {key=%d id=%v}", m.Key, m.ID)
+	return fmt.Sprintf("Query {key=%v id=%v}", m.Key, m.ID)
 }
 
 // Info is reply message for both query and Move message
@@ -98,7 +98,7 @@ type Info struct {
 }
 
 func (m Info) String() string {
-	return fmt.Sprintf("Info {key=%d b=%v ob=%v}", m.Key, m.Ballot, m.OldBallot)
+	return fmt.Sprintf("Info {key=%v b=%v ob=%v}", m.Key, m.Ballot, m.OldBallot)
 }
 
 // Move message suggest master to move an object
@@ -109,5 +109,5 @@ type Move struct {
 }
 
 func (m Move) String() string {
-	return fmt.Sprintf("Move {key=%d from=%v to=%v}", m.Key, m.From, m.To)
+	return fmt.Sprintf("Move {key=%v from=%v to=%v}", m.Key, m.From, m.To)
 }
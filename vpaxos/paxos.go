@@ -152,11 +152,12 @@ func (p *paxos) exec() {
 		if !ok || !e.commit {
 			break
 		}
-		value := p.Execute(e.command)
+		value, err := p.Execute(e.command)
 		if e.request != nil {
 			e.request.Reply(paxi.Reply{
 				Command: e.command,
 				Value:   value,
+				Err:     err,
 			})
 			e.request = nil
 		}
@@ -182,13 +182,14 @@ func (r *Replica) exec(key paxi.Key) {
 		}
 
 		log.Debugf("replica %s execute [s=%d, cmd=%v]", r.ID(), r.executed[key], e.cmd)
-		value := r.Execute(e.cmd)
+		value, err := r.Execute(e.cmd)
 		r.executed[key]++
 
 		if e.req != nil {
 			e.req.Reply(paxi.Reply{
 				Command: e.cmd,
 				Value:   value,
+				Err:     err,
 			})
 			e.req = nil
 		}
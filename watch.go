@@ -0,0 +1,109 @@
+package paxi
+
+import (
+	"strings"
+	"sync"
+)
+
+// Event is an executed write of key delivered to watchers in execution order
+type Event struct {
+	Key     Key       `json:"key"`
+	Value   Value     `json:"value"` // value after write, nil if deleted
+	Op      Operation `json:"op"`
+	Version int       `json:"version"` // sequence number of event in this node
+}
+
+// watchBufferSize is the number of undelivered events a watcher can hold before it is closed
+const watchBufferSize = 1024
+
+// watch is one subscription on key or key prefix
+type watch struct {
+	key    Key
+	prefix bool
+	c      chan Event
+}
+
+func (w *watch) match(k Key) bool {
+	if w.prefix {
+		return strings.HasPrefix(string(k), string(w.key))
+	}
+	return w.key == k
+}
+
+// watchers is the set of subscriptions of node
+type watchers struct {
+	sync.Mutex
+	version int
+	set     map[*watch]struct{}
+}
+
+// subscribe creates subscription of key, or every key starting with key if prefix is true
+func (ws *watchers) subscribe(key Key, prefix bool) *watch {
+	w := &watch{
+		key:    key,
+		prefix: prefix,
+		c:      make(chan Event, watchBufferSize),
+	}
+	ws.Lock()
+	defer ws.Unlock()
+	if ws.set == nil {
+		ws.set = make(map[*watch]struct{})
+	}
+	ws.set[w] = struct{}{}
+	return w
+}
+
+// unsubscribe removes subscription and closes its channel
+func (ws *watchers) unsubscribe(w *watch) {
+	ws.Lock()
+	defer ws.Unlock()
+	if _, exists := ws.set[w]; exists {
+		delete(ws.set, w)
+		close(w.c)
+	}
+}
+
+// publish delivers event to all matching subscriptions without blocking,
+// subscription that falls behind is closed so the client can resubscribe
+func (ws *watchers) publish(k Key, v Value, op Operation) {
+	ws.Lock()
+	defer ws.Unlock()
+	ws.version++
+	e := Event{Key: k, Value: v, Op: op, Version: ws.version}
+	for w := range ws.set {
+		if !w.match(k) {
+			continue
+		}
+		select {
+		case w.c <- e:
+		default:
+			delete(ws.set, w)
+			close(w.c)
+		}
+	}
+}
+
+// Execute overrides StateMachine interface to execute every command of client once
+// and notify watchers of every executed write
+func (n *node) Execute(c Command) (Value, error) {
+	return n.sessions.execute(c, n.execute)
+}
+
+func (n *node) execute(c Command) (Value, error) {
+	v, err := n.StateMachine.Execute(c)
+	if err != nil || c.IsRead() {
+		return v, err
+	}
+	db, isDB := n.StateMachine.(Database)
+	for _, cmd := range c.Commands() {
+		if cmd.IsRead() {
+			continue
+		}
+		value := v
+		if isDB {
+			value = db.Get(cmd.Key)
+		}
+		n.watchers.publish(cmd.Key, value, cmd.Operation())
+	}
+	return v, nil
+}
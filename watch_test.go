@@ -0,0 +1,51 @@
+package paxi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	n := &node{StateMachine: NewMemoryDatabase()}
+	key := n.watchers.subscribe("a", false)
+	prefix := n.watchers.subscribe("b/", true)
+
+	n.Execute(Command{Key: "a", Value: Value("1")})
+	n.Execute(Command{Key: "a"})
+	n.Execute(Command{Key: "b/1", Value: Value("x"), Op: OpAppend})
+	n.Execute(NewTxn([]Command{
+		{Key: "a", Op: OpDelete},
+		{Key: "b/1", Value: Value("y"), Op: OpAppend},
+	}))
+
+	e := <-key.c
+	if e.Key != "a" || !bytes.Equal(e.Value, Value("1")) || e.Op != OpPut {
+		t.Errorf("unexpected event %+v", e)
+	}
+	e = <-key.c
+	if e.Op != OpDelete || e.Value != nil {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if len(key.c) != 0 {
+		t.Errorf("read should not be watched, got %d more events", len(key.c))
+	}
+
+	<-prefix.c
+	e = <-prefix.c
+	if e.Key != "b/1" || !bytes.Equal(e.Value, Value("xy")) || e.Version != 4 {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	// watcher that falls behind is closed
+	for i := 0; i <= watchBufferSize; i++ {
+		n.Execute(Command{Key: "a", Value: Value("2")})
+	}
+	n.watchers.unsubscribe(prefix)
+	count := 0
+	for range key.c {
+		count++
+	}
+	if count != watchBufferSize {
+		t.Errorf("expected %d buffered events, got %d", watchBufferSize, count)
+	}
+}
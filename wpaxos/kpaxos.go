@@ -10,6 +10,7 @@ type kpaxos struct {
 	key paxi.Key
 	*paxos.Paxos
 	paxi.Policy
+	replica *Replica
 }
 
 func Q1(q *paxi.Quorum) bool {
@@ -26,17 +27,21 @@ func Q2(q *paxi.Quorum) bool {
 	return q.FGridQ2(*fz)
 }
 
-func newKPaxos(key paxi.Key, node paxi.Node) *kpaxos {
+func newKPaxos(key paxi.Key, r *Replica) *kpaxos {
 	k := &kpaxos{}
-	k.Node = node
+	k.Node = r.Node
 	k.key = key
 	k.Policy = paxi.NewPolicy()
+	k.replica = r
 
 	quorum := func(p *paxos.Paxos) {
 		p.Q1 = Q1
 		p.Q2 = Q2
 	}
 	k.Paxos = paxos.NewPaxos(k, quorum)
+	k.Paxos.Ready = func(cmd paxi.Command) bool {
+		return r.ready(key, cmd)
+	}
 
 	// zone := int(key)%paxi.GetConfig().Z() + 1
 	// id := paxi.NewID(zone, 1)
@@ -47,6 +52,24 @@ func newKPaxos(key paxi.Key, node paxi.Node) *kpaxos {
 	return k
 }
 
+// Execute overrides StateMachine interface in Node, transaction is executed once for all key logs
+func (k *kpaxos) Execute(cmd paxi.Command) (paxi.Value, error) {
+	if cmd.Operation() == paxi.OpTxn {
+		return k.replica.execute(cmd)
+	}
+	return k.Node.Execute(cmd)
+}
+
+// Forward overrides Node interface, transaction that lost its slot is proposed again into this key log
+// instead of forwarding, since logs of its other keys may have it already
+func (k *kpaxos) Forward(id paxi.ID, m paxi.Request) {
+	if m.Command.Operation() == paxi.OpTxn {
+		k.replica.lost(k.key, m)
+		return
+	}
+	k.Node.Forward(id, m)
+}
+
 // Broadcast overrides Socket interface in Node
 func (k *kpaxos) Broadcast(m interface{}) {
 	switch m := m.(type) {
@@ -13,7 +13,11 @@ var fz = flag.Int("fz", 0, "f_z fault tolerent zones")
 // Replica is WPaxos replica node
 type Replica struct {
 	paxi.Node
-	paxi map[paxi.Key]*kpaxos
+	paxi      map[paxi.Key]*kpaxos
+	txns      map[txnID]*txn // committed transactions in execution
+	pending   []paxi.Request // transactions waiting for leadership of all keys
+	rejoins   []rejoin       // transactions to propose again into key logs that lost them
+	unblocked []paxi.Key     // key logs to execute after transaction reached all its logs
 }
 
 // NewReplica create new Replica instance
@@ -21,6 +25,8 @@ func NewReplica(id paxi.ID) *Replica {
 	r := new(Replica)
 	r.Node = paxi.NewNode(id)
 	r.paxi = make(map[paxi.Key]*kpaxos)
+	r.txns = make(map[txnID]*txn)
+	r.pending = make([]paxi.Request, 0)
 
 	r.Register(paxi.Request{}, r.handleRequest)
 	r.Register(paxi.Transaction{}, r.handleTransaction)
@@ -35,12 +41,16 @@ func NewReplica(id paxi.ID) *Replica {
 
 func (r *Replica) init(key paxi.Key) {
 	if _, exists := r.paxi[key]; !exists {
-		r.paxi[key] = newKPaxos(key, r.Node)
+		r.paxi[key] = newKPaxos(key, r)
 	}
 }
 
 func (r *Replica) handleRequest(m paxi.Request) {
 	log.Debugf("Replica %s received %v\n", r.ID(), m)
+	if m.Command.Operation() == paxi.OpTxn {
+		r.handleTxn(m)
+		return
+	}
 	key := m.Command.Key
 	r.init(key)
 
@@ -66,7 +76,8 @@ func (r *Replica) handleRequest(m paxi.Request) {
 }
 
 func (r *Replica) handleTransaction(m paxi.Transaction) {
-	// TODO
+	log.Debugf("Replica %s received %v\n", r.ID(), m)
+	r.handleTxn(m.Request())
 }
 
 func (r *Replica) handlePrepare(m Prepare) {
@@ -78,6 +89,8 @@ func (r *Replica) handlePrepare(m Prepare) {
 func (r *Replica) handlePromise(m Promise) {
 	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, r.ID())
 	r.paxi[m.Key].HandleP1b(m.P1b)
+	r.retry()
+	r.resume()
 	// log.Debugf("Number of keys: %d", r.keys())
 }
 
@@ -85,17 +98,20 @@ func (r *Replica) handleAccept(m Accept) {
 	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Ballot.ID(), m, r.ID())
 	r.init(m.Key)
 	r.paxi[m.Key].HandleP2a(m.P2a)
+	r.resume()
 }
 
 func (r *Replica) handleAccepted(m Accepted) {
 	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.ID, m, r.ID())
 	r.paxi[m.Key].HandleP2b(m.P2b)
+	r.resume()
 }
 
 func (r *Replica) handleCommit(m Commit) {
 	log.Debugf("Replica %s ===[%v]===>>> Replica %s\n", m.Ballot.ID(), m, r.ID())
 	r.init(m.Key)
 	r.paxi[m.Key].HandleP3(m.P3)
+	r.resume()
 }
 
 func (r *Replica) handleLeaderChange(m LeaderChange) {
@@ -0,0 +1,174 @@
+package wpaxos
+
+import (
+	"github.com/ailidani/paxi"
+	"github.com/ailidani/paxi/log"
+)
+
+// txnID identifies one proposal of transaction by its client, session, command id and timestamp
+type txnID struct {
+	client    paxi.ID
+	session   int64
+	command   int
+	timestamp int64
+}
+
+func idOf(cmd paxi.Command) txnID {
+	return txnID{cmd.ClientID, cmd.Session, cmd.CommandID, cmd.Timestamp}
+}
+
+// txn is the execution state of a committed transaction in logs of its keys
+// transaction is executed once when it reaches the execution point of every key log
+type txn struct {
+	arrived  map[paxi.Key]bool // key logs that reached the transaction
+	executed int               // number of key logs executed the transaction
+	value    paxi.Value
+	err      error
+}
+
+// rejoin is a transaction to propose again into log of key that lost it
+type rejoin struct {
+	key     paxi.Key
+	request paxi.Request
+}
+
+// handleTxn proposes transaction to logs of all its keys if this replica leads all of them,
+// otherwise the transaction waits until phase 1 of missing keys finish
+func (r *Replica) handleTxn(m paxi.Request) {
+	keys := m.Command.Keys()
+	for _, key := range keys {
+		r.init(key)
+	}
+	if r.lead(keys) {
+		r.propose(m)
+		r.resume()
+		return
+	}
+	r.pending = append(r.pending, m)
+	r.steal(keys)
+}
+
+// lead indicates if this replica is active leader of all keys
+func (r *Replica) lead(keys []paxi.Key) bool {
+	for _, key := range keys {
+		if !r.paxi[key].Active() {
+			return false
+		}
+	}
+	return true
+}
+
+// steal starts phase 1 for keys that are not led by this replica
+func (r *Replica) steal(keys []paxi.Key) {
+	for _, key := range keys {
+		p := r.paxi[key]
+		if !p.Active() && p.Ballot().ID() != r.ID() {
+			log.Debugf("Replica %s steals key %v for transaction", r.ID(), key)
+			p.P1a()
+		}
+	}
+}
+
+// propose proposes transaction into the same position of every key log in one step,
+// so transactions of one leader are ordered the same in all logs,
+// every key log keeps the request to propose it again if lost, only the first key log replies to client
+func (r *Replica) propose(m paxi.Request) {
+	for i, key := range m.Command.Keys() {
+		req := paxi.Request{Command: m.Command, Timestamp: m.Timestamp}
+		if i == 0 {
+			req = m
+		}
+		r.paxi[key].P2a(&req)
+	}
+}
+
+// lost queues transaction that lost its slot in log of key to phase 1 of another leader,
+// it is proposed again into the log by resume so that logs of its other keys are not blocked
+func (r *Replica) lost(key paxi.Key, m paxi.Request) {
+	log.Debugf("Replica %s proposes transaction %v again to key %v", r.ID(), m.Command, key)
+	r.rejoins = append(r.rejoins, rejoin{key, m})
+}
+
+// resume runs work left by handling of message: it executes key logs unblocked by transactions,
+// and proposes lost transactions again into their key logs, stealing keys it does not lead
+func (r *Replica) resume() {
+	for len(r.unblocked) > 0 {
+		key := r.unblocked[0]
+		r.unblocked = r.unblocked[1:]
+		r.paxi[key].Exec()
+	}
+	rejoins := r.rejoins
+	r.rejoins = nil
+	for _, j := range rejoins {
+		p := r.paxi[j.key]
+		if p.Active() {
+			req := j.request
+			p.P2a(&req)
+			continue
+		}
+		r.rejoins = append(r.rejoins, j)
+		if p.Ballot().ID() != r.ID() {
+			p.P1a()
+		}
+	}
+}
+
+// retry proposes pending transactions after phase 1 of any key finished
+func (r *Replica) retry() {
+	pending := r.pending
+	r.pending = make([]paxi.Request, 0)
+	for _, m := range pending {
+		keys := m.Command.Keys()
+		if r.lead(keys) {
+			r.propose(m)
+		} else {
+			r.pending = append(r.pending, m)
+			r.steal(keys)
+		}
+	}
+}
+
+// ready marks committed transaction reached execution point of key log,
+// returns true when it reached logs of all its keys, other key logs waiting for it are resumed after
+func (r *Replica) ready(key paxi.Key, cmd paxi.Command) bool {
+	if cmd.Operation() != paxi.OpTxn {
+		return true
+	}
+	keys := cmd.Keys()
+	id := idOf(cmd)
+	t, exists := r.txns[id]
+	if !exists {
+		t = &txn{arrived: make(map[paxi.Key]bool)}
+		r.txns[id] = t
+	}
+	if t.arrived[key] {
+		return len(t.arrived) == len(keys)
+	}
+	t.arrived[key] = true
+	if len(t.arrived) < len(keys) {
+		return false
+	}
+	for _, k := range keys {
+		if k != key {
+			r.unblocked = append(r.unblocked, k)
+		}
+	}
+	return true
+}
+
+// execute executes transaction against state machine once and returns the same result to every key log
+func (r *Replica) execute(cmd paxi.Command) (paxi.Value, error) {
+	id := idOf(cmd)
+	t := r.txns[id]
+	if t == nil {
+		return r.Node.Execute(cmd)
+	}
+	if t.executed == 0 {
+		t.value, t.err = r.Node.Execute(cmd)
+	}
+	t.executed++
+	if t.executed == len(t.arrived) {
+		delete(r.txns, id)
+	}
+	return t.value, t.err
+}